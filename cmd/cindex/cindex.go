@@ -5,21 +5,33 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/pprof"
 	"slices"
+	"strings"
 
+	"github.com/google/codesearch/ignore"
 	"github.com/google/codesearch/index"
 )
 
-var usageMessage = `usage: cindex [-list] [-reset] [-zip] [path...]
+var usageMessage = `usage: cindex [-follow [-canonical]] [-full] [-j n] [-list] [-noignore] [-reset] [-zip] [path...]
+       cindex -git [-full] [-j n] [-reset] [-zip] repo@rev...
+       cindex -files-from=file [-0] -root=dir [-full] [-j n] [-reset] [-zip]
+       cindex -remove path...
+       cindex -init [-noignore] [-zip]
 
 Cindex prepares the trigram index for use by csearch.  The index is the
 file named by $CSEARCHINDEX, or else $HOME/.csearchindex.
+If $CSEARCHINDEX lists several files, as described in csearch -help,
+cindex updates the first one. If $CSEARCHINDEX is unset, a project
+index (see -init below) in the current directory or one of its parents
+takes precedence over $HOME/.csearchindex.
 
 The simplest invocation is
 
@@ -39,9 +51,113 @@ If cindex is invoked with no paths, it reindexes the paths that have
 already been added, in case the files have changed.  Thus, 'cindex' by
 itself is a useful command to run in a nightly cron job.
 
-The -list flag causes cindex to list the paths it has indexed and exit.
+Cindex records the flags that affect how it indexes a path (-canonical,
+-follow, -git, -limit, -maxfile, -maxline, -maxtrigrams, -noignore,
+-partial and -zip)
+in the index along with each path, and reindexing with no paths uses the
+flags recorded for each path, so that every path is indexed the same
+way it was when it was added. Flags given along with no paths override
+the recorded ones.
 
-The -zip flag causes cindex to index content inside ZIP files.
+Cindex writes the new index to a temporary file and renames it into
+place at the end, so an interrupted cindex leaves the index unchanged.
+
+When updating an existing index, cindex only reads files whose size or
+modification time differs from what the index recorded, reusing the
+existing index data for the rest. With -zip, the same goes for an
+archive as a whole: cindex reuses the files in it unless the archive
+itself changed. The -full flag causes cindex to read every file again.
+
+The -j flag sets the number of files cindex reads at once (default 1).
+The index is the same no matter how many files are read at once.
+
+Cindex only indexes files that look like text: they must be valid
+UTF-8 without NUL bytes, and by default they must be at most 1 GB long,
+with lines of at most 2000 bytes and at most 20000 distinct trigrams.
+Files in UTF-16, recognized by a byte order mark or by the NUL bytes
+in ASCII text, and files in Latin-1, recognized by being invalid UTF-8
+without control characters, are decoded to UTF-8 and then indexed.
+Csearch decodes them the same way when searching them.
+The -maxfile, -maxline and -maxtrigrams flags change those limits;
+a negative limit means no limit. The -limit flag overrides the limits
+for files whose names match a glob pattern; for example,
+
+	cindex -limit='*.json:maxline=-1' -limit='*.min.js:maxline=500' path...
+
+allows any line length in JSON files but only 500 bytes in minified
+JavaScript. The pattern matches the final element of the file name,
+or the whole name if it contains a slash. If several patterns match,
+the last one applies.
+
+The -partial flag causes cindex to index files that exceed the limits
+partially instead of skipping them: it indexes only the first -maxfile
+bytes of a file, only the first -maxline bytes of each line, and only
+the first -maxtrigrams distinct trigrams. Files that are not valid
+UTF-8 or contain NUL bytes are still skipped. The index records which
+files are partially indexed, and csearch searches all of them no matter
+what the pattern is, so that it still finds every match.
+
+Cindex skips files and directories whose names begin with '.', '#'
+or '~' or end with '~'. It also skips paths excluded by .gitignore and
+.csearchignore files, which use the gitignore pattern format, and by
+a global ignore file, named by $CSEARCHIGNORE or else
+$XDG_CONFIG_HOME/codesearch/ignore (on Unix). The rules in an ignore
+file apply to the paths under the directory containing it, and rules
+in .csearchignore take precedence over those in .gitignore.
+With -verbose, cindex prints the ignore rules as it reads them.
+The -noignore flag disables the ignore files.
+
+At the end of a run, cindex logs how many files it skipped for each
+reason. The -skipped flag causes cindex to also write a report of the
+skipped files to the named file, as a sequence of JSON objects, one per
+line, with the fields "path", "reason" and "size". The reason is one of
+"NUL", "invalid UTF-8", "too long", "long lines", "too many trigrams"
+or "malformed name". The report does not list the hidden and ignored
+files that cindex does not read at all.
+
+By default cindex does not follow symbolic links. The -follow flag
+causes it to follow them, indexing the files and directories they refer
+to as if they were in the link's place. Cindex indexes each file or
+directory only once, even if several links lead to it, and it stops at
+links that lead back to a directory it has already visited, so loops
+are harmless. A file reached several ways is indexed under the first
+of its paths in sorted order. With -canonical, cindex instead indexes
+each file under its canonical path: the path with all links resolved,
+relative to the root being indexed. A file whose canonical path is
+outside the root keeps the path by which cindex reached it.
+
+The -git flag causes cindex to index revisions of git repositories
+without checking them out. Each argument has the form repo@rev,
+where repo is the repository directory and rev is any revision git
+understands, such as a branch, tag or commit hash. Cindex reads the
+files using the git command and indexes them under names of the form
+repo@rev/path, and csearch and csweb read them back from the same
+revision. If rev is a branch, reindexing picks up its new commits.
+
+The -files-from flag causes cindex to index exactly the files listed in
+the named file, or on standard input if the name is "-", instead of
+walking a directory tree. The names in the list are separated by
+newlines, or by NUL bytes with -0, and relative names are relative to
+the directory given by the -root flag, which is required. Cindex adds
+that directory to the index as the root of the listed files, replacing
+whatever was indexed under it before, and skips listed files outside it.
+It does not skip hidden or ignored files in the list. For example:
+
+	git -C $HOME/src/proj ls-files -z | cindex -files-from=- -0 -root=$HOME/src/proj
+
+Cindex does not record the list, so reindexing with no paths walks the
+root's directory tree; run cindex -files-from again to update the index
+from a new list.
+
+The -list flag causes cindex to list the paths it has indexed,
+along with their recorded flags, and exit.
+
+The -zip flag causes cindex to index content inside archives: ZIP
+files (.zip), tar files (.tar), compressed tar files (.tar.gz and .tgz)
+and gzip-compressed files (.gz). A file in an archive is indexed under
+the archive's name, a \x01 byte and the file's name in the archive;
+a .gz file holds a single file named for it without the .gz suffix.
+Csearch and csweb read matching files back out of the archives.
 This feature is experimental and will almost certainly change
 in the future, possibly in incompatible ways.
 
@@ -50,6 +166,16 @@ information about other paths that might already be indexed
 (the ones printed by cindex -list).  The -reset flag causes cindex to
 delete the existing index before indexing the new paths.
 With no path arguments, cindex -reset removes the index.
+
+The -remove flag causes cindex to remove the named paths, and the
+files under them, from the index without reindexing anything else.
+Each path must be one of the paths printed by cindex -list,
+or contain one of them.
+
+The -init flag causes cindex to create a project index: a file named
+.csearchindex in the current directory, indexing that directory.
+Afterward, cindex and csearch use that index when run in the directory
+or any of its subdirectories, unless $CSEARCHINDEX is set.
 `
 
 func usage() {
@@ -63,8 +189,15 @@ var (
 	verboseFlag = flag.Bool("verbose", false, "print extra information")
 	cpuProfile  = flag.String("cpuprofile", "", "write cpu profile to this file")
 	checkFlag   = flag.Bool("check", false, "check index is well-formatted")
-	zipFlag     = flag.Bool("zip", false, "index content in zip files")
 	statsFlag   = flag.Bool("stats", false, "print index size statistics")
+	fullFlag    = flag.Bool("full", false, "reread unchanged files instead of reusing existing index data")
+	removeFlag  = flag.Bool("remove", false, "remove paths from index")
+	initFlag    = flag.Bool("init", false, "create a project index for the current directory")
+	jobsFlag    = flag.Int("j", 1, "read `n` files at once")
+	skippedFlag = flag.String("skipped", "", "write a report of skipped files to `file`")
+	filesFlag   = flag.String("files-from", "", "index the files listed in `file`")
+	nulFlag     = flag.Bool("0", false, "with -files-from, names are separated by NUL bytes")
+	rootFlag    = flag.String("root", "", "with -files-from, the root `dir` for the listed files")
 )
 
 func main() {
@@ -72,19 +205,42 @@ func main() {
 	flag.Usage = usage
 	flag.Parse()
 
+	master := index.File()
+	if *initFlag {
+		if flag.NArg() != 0 || *removeFlag || *listFlag {
+			usage()
+		}
+		dir, err := os.Getwd()
+		if err != nil {
+			log.Fatal(err)
+		}
+		master = filepath.Join(dir, ".csearchindex")
+		*resetFlag = true
+	}
+
 	if *listFlag {
-		ix := index.Open(index.File())
+		ix := index.OpenSet(master)
 		if *checkFlag {
-			if err := ix.Check(); err != nil {
-				log.Fatal(err)
+			for _, shard := range ix.Shards() {
+				if err := shard.Check(); err != nil {
+					log.Fatal(err)
+				}
 			}
 		}
-		for p := range ix.Roots().All() {
-			fmt.Printf("%s\n", p)
+		for p := range ix.Roots() {
+			if opts := ix.RootOptions(p); len(opts) > 0 {
+				fmt.Printf("%s\t%s\n", p, strings.Join(opts, " "))
+			} else {
+				fmt.Printf("%s\n", p)
+			}
 		}
 		return
 	}
 
+	if index.IsManifest(master) {
+		log.Fatalf("%s is a shard manifest; run cindex on each shard instead", master)
+	}
+
 	if *cpuProfile != "" {
 		f, err := os.Create(*cpuProfile)
 		if err != nil {
@@ -95,19 +251,37 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	if *resetFlag && flag.NArg() == 0 {
-		os.Remove(index.File())
+	if *resetFlag && flag.NArg() == 0 && !*initFlag && *filesFlag == "" {
+		os.Remove(master)
 		return
 	}
 	var roots []index.Path
-	if flag.NArg() == 0 {
-		ix := index.Open(index.File())
-		roots = slices.Collect(ix.Roots().All())
-	} else {
+	var listed []string
+	if *filesFlag != "" {
+		if flag.NArg() != 0 || *rootFlag == "" || *initFlag || *removeFlag {
+			usage()
+		}
+		root, err := filepath.Abs(*rootFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listed, err = readFileList(*filesFlag, *nulFlag, root)
+		if err != nil {
+			log.Fatal(err)
+		}
+		roots = []index.Path{index.MakePath(root)}
+	} else if *initFlag {
+		roots = []index.Path{index.MakePath(filepath.Dir(master))}
+	} else if flag.NArg() != 0 {
 		// Translate arguments to absolute paths so that
 		// we can generate the file list in sorted order.
+		roots = []index.Path{} // not nil, which means to reindex
+		opts, _, err := resolveRootOptions(nil)
+		if err != nil {
+			log.Fatal(err)
+		}
 		for _, arg := range flag.Args() {
-			a, err := filepath.Abs(arg)
+			a, err := absRoot(arg, opts.git)
 			if err != nil {
 				log.Printf("%s: %s", arg, err)
 				continue
@@ -117,72 +291,63 @@ func main() {
 		slices.SortFunc(roots, index.Path.Compare)
 	}
 
-	master := index.File()
-	if _, err := os.Stat(master); err != nil {
-		// Does not exist.
-		*resetFlag = true
-	}
-	file := master
-	if !*resetFlag {
-		file += "~"
+	if *removeFlag {
+		if flag.NArg() == 0 {
+			usage()
+		}
+		file := master + "~"
+		if err := index.Remove(file, master, roots); err != nil {
+			os.Remove(file)
+			log.Fatal(err)
+		}
 		if *checkFlag {
-			ix := index.Open(master)
+			ix := index.Open(file)
 			if err := ix.Check(); err != nil {
 				log.Fatal(err)
 			}
 		}
+		os.Rename(file, master)
+		log.Printf("done")
+		return
 	}
 
-	ix := index.Create(file)
-	ix.Verbose = *verboseFlag
-	ix.Zip = *zipFlag
-	ix.AddRoots(roots)
-	for _, root := range roots {
-		log.Printf("index %s", root)
-		filepath.Walk(root.String(), func(path string, info os.FileInfo, err error) error {
-			if _, elem := filepath.Split(path); elem != "" {
-				// Skip various temporary or "hidden" files or directories.
-				if elem[0] == '.' || elem[0] == '#' || elem[0] == '~' || elem[len(elem)-1] == '~' {
-					if info.IsDir() {
-						return filepath.SkipDir
-					}
-					return nil
-				}
-			}
-			if err != nil {
-				log.Printf("%s: %s", path, err)
-				return nil
-			}
-			if info != nil && info.Mode()&os.ModeType == 0 {
-				if err := ix.AddFile(path); err != nil {
-					log.Printf("%s: %s", path, err)
-					return nil
-				}
-			}
-			return nil
-		})
+	skipped, err := newSkipReport(*skippedFlag)
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.Printf("flush index")
-	ix.Flush()
-
-	if !*resetFlag {
-		log.Printf("merge %s %s", master, file)
-		index.Merge(file+"~", master, file)
-		if *checkFlag {
-			ix := index.Open(file + "~")
-			if err := ix.Check(); err != nil {
-				log.Fatal(err)
+	global := globalIgnore()
+	opts := index.Options{
+		Reset:   *resetFlag,
+		Full:    *fullFlag,
+		Check:   *checkFlag,
+		Verbose: *verboseFlag,
+		Workers: *jobsFlag,
+		Skipped: skipped.add,
+		Root: func(root index.Path, recorded []string) (*index.RootConfig, error) {
+			if flag.NArg() != 0 {
+				recorded = nil // index the named paths afresh
 			}
-		}
-		os.Remove(file)
-		os.Rename(file+"~", master)
-	} else {
-		if *checkFlag {
-			ix := index.Open(file)
-			if err := ix.Check(); err != nil {
-				log.Fatal(err)
+			return rootConfig(root, recorded, global, listed)
+		},
+		Progress: func(p index.Progress) {
+			switch p.Stage {
+			case index.StageRoot:
+				log.Printf("index %s", p.Root)
+			case index.StageFlush:
+				log.Printf("flush index")
+			case index.StageMerge:
+				log.Printf("merge %s %s~", master, master)
 			}
-		}
+		},
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = index.Build(ctx, master, roots, opts)
+	if err := skipped.close(); err != nil {
+		log.Fatal(err)
+	}
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	log.Printf("done")
@@ -193,3 +358,101 @@ func main() {
 	}
 	return
 }
+
+// absRoot returns the absolute form of the root named by arg.
+// For a git root, repo@rev, only the repository directory is made
+// absolute; the revision is left as is.
+func absRoot(arg string, git bool) (string, error) {
+	if !git {
+		return filepath.Abs(arg)
+	}
+	dir, rev, ok := index.SplitGitRoot(arg)
+	if !ok {
+		return "", fmt.Errorf("not of the form repo@rev")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return dir + "@" + rev, nil
+}
+
+// rootConfig returns the configuration for indexing root, which was
+// last indexed with the recorded options. If listed is non-nil, it is
+// the list of files to index from -files-from.
+func rootConfig(root index.Path, recorded []string, global []*ignore.Rule, listed []string) (*index.RootConfig, error) {
+	opts, args, err := resolveRootOptions(recorded)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &index.RootConfig{
+		Options:        args,
+		Zip:            opts.zip,
+		Limits:         opts.limits,
+		LimitOverrides: opts.overrides,
+		Partial:        opts.partial,
+		Follow:         opts.follow,
+		Canonical:      opts.canonical,
+	}
+	switch {
+	case listed != nil:
+		cfg.Add = func(ctx context.Context, ix *index.IndexWriter) error {
+			return addFileList(ctx, ix, listed)
+		}
+	case opts.git:
+		cfg.Add = func(ctx context.Context, ix *index.IndexWriter) error {
+			dir, rev, ok := index.SplitGitRoot(root.String())
+			if !ok {
+				return fmt.Errorf("%s: not of the form repo@rev", root)
+			}
+			skip := func(path string) bool {
+				return slices.ContainsFunc(strings.Split(path, "/"), index.IsHidden)
+			}
+			return ix.AddGit(ctx, dir, rev, skip)
+		}
+	default:
+		w := ignore.NewWalker(root.String(), global, ".gitignore", ".csearchignore")
+		if opts.noIgnore {
+			w = ignore.NewWalker(root.String(), nil)
+		}
+		w.Load = func(file string, rules []*ignore.Rule, err error) {
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			logIgnore(rules)
+		}
+		cfg.Skip = w.Skip
+	}
+	return cfg, nil
+}
+
+// globalIgnore returns the rules in the global ignore file.
+func globalIgnore() []*ignore.Rule {
+	file := os.Getenv("CSEARCHIGNORE")
+	if file == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return nil
+		}
+		file = filepath.Join(dir, "codesearch", "ignore")
+	}
+	rules, err := ignore.ReadFile(file, "")
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Print(err)
+		}
+		return nil
+	}
+	logIgnore(rules)
+	return rules
+}
+
+// logIgnore logs the ignore rules, if -verbose is set.
+func logIgnore(rules []*ignore.Rule) {
+	if *verboseFlag {
+		for _, r := range rules {
+			log.Printf("ignore %s", r)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/codesearch/index"
+)
+
+// readFileList reads the list of files to index from the named file,
+// or from standard input if file is "-". The names are separated by
+// newlines, or by NUL bytes if nul is set. Relative names are relative
+// to root. readFileList returns the absolute names of the listed files
+// in index order, without duplicates, leaving out and logging the ones
+// that are not under root. The list it returns is never nil, even if
+// no files are listed, so that rootConfig indexes only the listed files.
+func readFileList(file string, nul bool, root string) ([]string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sep := []byte("\n")
+	if nul {
+		sep = []byte("\x00")
+	}
+	names := []string{}
+	for _, line := range bytes.Split(data, sep) {
+		name := string(line)
+		if !nul {
+			name = strings.TrimSuffix(name, "\r")
+		}
+		if name == "" {
+			continue
+		}
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(root, name)
+		}
+		name = filepath.Clean(name)
+		if rel, err := filepath.Rel(root, name); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			log.Printf("%s: not in %s", name, root)
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(x, y string) int {
+		return index.MakePath(x).Compare(index.MakePath(y))
+	})
+	return slices.Compact(names), nil
+}
+
+// addFileList adds the listed files to the index.
+// If ctx is canceled, it stops and returns ctx.Err().
+func addFileList(ctx context.Context, ix *index.IndexWriter, names []string) error {
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		info, err := os.Stat(name)
+		if err == nil && !info.Mode().IsRegular() {
+			err = fmt.Errorf("not a regular file")
+		}
+		if err == nil {
+			err = ix.AddFile(name)
+		}
+		if err != nil {
+			log.Printf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/codesearch/index"
+)
+
+// captureLog redirects the log output to a buffer for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestReadFileList(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	name := func(s string) string { return filepath.Join(root, filepath.FromSlash(s)) }
+
+	for _, tt := range []struct {
+		name string
+		nul  bool
+		list string
+		want []string
+		log  []string
+	}{
+		{
+			name: "newlines",
+			list: "b.c\na/x.c\n\n" + name("a.c") + "\n",
+			want: []string{name("a/x.c"), name("a.c"), name("b.c")},
+		},
+		{
+			name: "crlf",
+			list: "b.c\r\na.c\r\n",
+			want: []string{name("a.c"), name("b.c")},
+		},
+		{
+			name: "nul",
+			nul:  true,
+			list: "with\nnewline.c\x00b.c\r\x00a.c\x00",
+			want: []string{name("a.c"), name("b.c\r"), name("with\nnewline.c")},
+		},
+		{
+			name: "empty",
+			list: "",
+			want: []string{},
+		},
+		{
+			name: "alloutside",
+			list: "../x.c\n",
+			want: []string{},
+			log:  []string{filepath.Join(dir, "x.c") + ": not in " + root},
+		},
+		{
+			name: "duplicates",
+			list: "a.c\n./a.c\nsub/../a.c\n" + name("a.c") + "\n",
+			want: []string{name("a.c")},
+		},
+		{
+			name: "outside",
+			list: "../x.c\n" + filepath.Join(dir, "other", "y.c") + "\n" + dir + "\n.\nin.c\n",
+			want: []string{name("in.c")},
+			log: []string{
+				filepath.Join(dir, "x.c") + ": not in " + root,
+				filepath.Join(dir, "other", "y.c") + ": not in " + root,
+				dir + ": not in " + root,
+				root + ": not in " + root,
+			},
+		},
+	} {
+		buf := captureLog(t)
+		file := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(file, []byte(tt.list), 0666); err != nil {
+			t.Fatal(err)
+		}
+		names, err := readFileList(file, tt.nul, root)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if names == nil || !slices.Equal(names, tt.want) {
+			t.Errorf("%s: readFileList = %q, want %q", tt.name, names, tt.want)
+		}
+		var logged []string
+		if s := strings.TrimSuffix(buf.String(), "\n"); s != "" {
+			logged = strings.Split(s, "\n")
+		}
+		if !slices.Equal(logged, tt.log) {
+			t.Errorf("%s: logged %q, want %q", tt.name, logged, tt.log)
+		}
+	}
+
+	if _, err := readFileList(filepath.Join(dir, "missing"), false, root); err == nil {
+		t.Errorf("readFileList(missing) succeeded")
+	}
+}
+
+func TestAddFileList(t *testing.T) {
+	dir := t.TempDir()
+	name := func(s string) string { return filepath.Join(dir, filepath.FromSlash(s)) }
+	for _, f := range []string{"a/x.c", "a.c", "b.c"} {
+		if err := os.MkdirAll(filepath.Dir(name(f)), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name(f), []byte("int "+f+";\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	buf := captureLog(t)
+	out := filepath.Join(t.TempDir(), "index")
+	ix := index.Create(out)
+	ix.AddRoots([]index.Path{index.MakePath(dir)})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := addFileList(ctx, ix, []string{name("a.c")}); err != context.Canceled {
+		t.Errorf("addFileList with canceled context = %v, want %v", err, context.Canceled)
+	}
+	if err := addFileList(context.Background(), ix, []string{name("a"), name("a/x.c"), name("a.c"), name("b.c"), name("c.c")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Directories and missing files are logged and left out.
+	logged := buf.String()
+	for _, f := range []string{"a", "c.c"} {
+		if !strings.Contains(logged, name(f)+": ") {
+			t.Errorf("log does not mention %s:\n%s", name(f), logged)
+		}
+	}
+	if strings.Count(logged, "\n") != 2 {
+		t.Errorf("log has unexpected lines:\n%s", logged)
+	}
+
+	ix.Flush()
+	rx := index.Open(out)
+	want := []string{name("a/x.c"), name("a.c"), name("b.c")}
+	if n := len(rx.PostingList(uint32('i')<<16 | uint32('n')<<8 | uint32('t'))); n != len(want) {
+		t.Fatalf("index has %d files, want %d", n, len(want))
+	}
+	for i, w := range want {
+		if n := rx.Name(i).String(); n != w {
+			t.Errorf("Name(%d) = %s, want %s", i, n, w)
+		}
+	}
+}
+
+func TestRootConfigEmptyList(t *testing.T) {
+	// An empty list adds no files instead of walking the root.
+	cfg, err := rootConfig(index.MakePath(t.TempDir()), nil, nil, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Add == nil || cfg.Skip != nil {
+		t.Errorf("rootConfig with empty list does not add the listed files")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/codesearch/index"
+)
+
+// Per-root options.
+//
+// Some flags affect how cindex indexes a root, not just what this run
+// of cindex does. Cindex records those flags in the index for each root,
+// so that running cindex with no path arguments reindexes each root
+// the way it was indexed before, and cindex -list shows them.
+
+// A rootOptions holds the per-root options for a root.
+type rootOptions struct {
+	zip       bool         // index content in archives
+	noIgnore  bool         // do not read ignore files
+	limits    index.Limits // limits for detecting text files
+	overrides limitList    // limits for files matching patterns
+	partial   bool         // index files exceeding the limits partially
+	git       bool         // root is a git repository and revision, repo@rev
+	follow    bool         // follow symbolic links
+	canonical bool         // name files reached through links by their canonical paths
+}
+
+// define defines the per-root flags in fs, storing their values in o.
+func (o *rootOptions) define(fs *flag.FlagSet) {
+	fs.BoolVar(&o.zip, "zip", false, "index content in zip, tar and gzip archives")
+	fs.BoolVar(&o.noIgnore, "noignore", false, "do not read .gitignore, .csearchignore or the global ignore file")
+	def := index.DefaultLimits
+	fs.Int64Var(&o.limits.MaxFileLen, "maxfile", def.MaxFileLen, "skip files longer than `n` bytes")
+	fs.IntVar(&o.limits.MaxLineLen, "maxline", def.MaxLineLen, "skip files with lines longer than `n` bytes")
+	fs.IntVar(&o.limits.MaxTextTrigrams, "maxtrigrams", def.MaxTextTrigrams, "skip files with more than `n` distinct trigrams")
+	fs.Var(&o.overrides, "limit", "override limits for files matching a pattern (`glob:name=n,...`)")
+	fs.BoolVar(&o.partial, "partial", false, "index files exceeding the limits partially instead of skipping them")
+	fs.BoolVar(&o.git, "git", false, "index revisions of git repositories, given as `repo@rev`")
+	fs.BoolVar(&o.follow, "follow", false, "follow symbolic links")
+	fs.BoolVar(&o.canonical, "canonical", false, "with -follow, index files by their paths with links resolved")
+}
+
+func init() {
+	new(rootOptions).define(flag.CommandLine)
+}
+
+// resolveRootOptions returns the options for indexing a root
+// that was last indexed with the recorded options,
+// as overridden by the per-root flags on the command line.
+// It also returns the arguments to record for the root.
+func resolveRootOptions(recorded []string) (rootOptions, []string, error) {
+	var o rootOptions
+	fs := flag.NewFlagSet("cindex", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o.define(fs)
+	if err := fs.Parse(recorded); err != nil {
+		log.Printf("ignoring recorded options %q: %v", recorded, err)
+		o = rootOptions{}
+		fs = flag.NewFlagSet("cindex", flag.ContinueOnError)
+		o.define(fs)
+	}
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		if rf := fs.Lookup(f.Name); rf != nil && err == nil {
+			if l, ok := rf.Value.(*limitList); ok {
+				*l = nil // replace the recorded list, not add to it
+			}
+			if e := fs.Set(f.Name, f.Value.String()); e != nil {
+				err = fmt.Errorf("-%s: %v", f.Name, e)
+			}
+		}
+	})
+	if err != nil {
+		return rootOptions{}, nil, err
+	}
+
+	args := []string{}
+	fs.Visit(func(f *flag.Flag) {
+		switch v := f.Value.String(); {
+		case v == f.DefValue:
+			// Not worth recording.
+		case v == "true" && isBoolFlag(f):
+			args = append(args, "-"+f.Name)
+		default:
+			args = append(args, "-"+f.Name+"="+v)
+		}
+	})
+	return o, args, nil
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// A limitList is a list of limit overrides, for the -limit flag.
+// Each override has the form glob:name=n,..., where each name is
+// maxfile, maxline or maxtrigrams. Overrides are separated by
+// semicolons, or given in separate -limit flags.
+type limitList []index.LimitOverride
+
+func (l *limitList) String() string {
+	var parts []string
+	for _, o := range *l {
+		var fields []string
+		if o.MaxFileLen != 0 {
+			fields = append(fields, fmt.Sprintf("maxfile=%d", o.MaxFileLen))
+		}
+		if o.MaxLineLen != 0 {
+			fields = append(fields, fmt.Sprintf("maxline=%d", o.MaxLineLen))
+		}
+		if o.MaxTextTrigrams != 0 {
+			fields = append(fields, fmt.Sprintf("maxtrigrams=%d", o.MaxTextTrigrams))
+		}
+		if len(fields) == 0 {
+			// Overriding no limits; spell that out so Set accepts it.
+			fields = []string{"maxfile=0", "maxline=0", "maxtrigrams=0"}
+		}
+		parts = append(parts, o.Pattern+":"+strings.Join(fields, ","))
+	}
+	return strings.Join(parts, ";")
+}
+
+func (l *limitList) Set(s string) error {
+	for _, part := range strings.Split(s, ";") {
+		i := strings.LastIndex(part, ":")
+		if i <= 0 {
+			return fmt.Errorf("malformed limit override %q", part)
+		}
+		o := index.LimitOverride{Pattern: part[:i]}
+		for _, field := range strings.Split(part[i+1:], ",") {
+			name, val, ok := strings.Cut(field, "=")
+			n, err := strconv.ParseInt(val, 0, 64)
+			if !ok || err != nil {
+				return fmt.Errorf("malformed limit %q", field)
+			}
+			switch name {
+			case "maxfile":
+				o.MaxFileLen = n
+			case "maxline":
+				o.MaxLineLen = int(n)
+			case "maxtrigrams":
+				o.MaxTextTrigrams = int(n)
+			default:
+				return fmt.Errorf("unknown limit %q", name)
+			}
+		}
+		*l = append(*l, o)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLimitList(t *testing.T) {
+	for _, s := range []string{
+		"*.json:maxline=0",
+		"*.json:maxline=-1;big/*:maxfile=100,maxtrigrams=5",
+		"*.c:maxfile=0,maxline=10",
+	} {
+		var l limitList
+		if err := l.Set(s); err != nil {
+			t.Fatalf("Set(%q): %v", s, err)
+		}
+		// String must give back a value that Set accepts
+		// and that means the same.
+		var l2 limitList
+		if err := l2.Set(l.String()); err != nil {
+			t.Errorf("Set(%q) = %v, from String of Set(%q)", l.String(), err, s)
+			continue
+		}
+		if !slices.Equal(l, l2) {
+			t.Errorf("Set(%q).String() = %q, which sets %v, want %v", s, l.String(), l2, l)
+		}
+	}
+
+	// Recorded zero overrides are read back.
+	recorded := []string{"-limit=*.json:maxfile=0,maxline=0,maxtrigrams=0", "-zip"}
+	opts, args, err := resolveRootOptions(recorded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.overrides) != 1 || !opts.zip || !slices.Equal(args, recorded) {
+		t.Errorf("resolveRootOptions(%q) = %v, %q, want the recorded options", recorded, opts.overrides, args)
+	}
+}
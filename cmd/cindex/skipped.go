@@ -0,0 +1,80 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/google/codesearch/index"
+)
+
+// A skipReport counts the files that the IndexWriter skips,
+// by reason, and optionally writes a report listing them.
+type skipReport struct {
+	counts map[index.SkipReason]int
+	file   *os.File
+	w      *bufio.Writer
+	enc    *json.Encoder
+}
+
+// A skipRecord is a single line in the -skipped report.
+type skipRecord struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	Size   int64  `json:"size"`
+}
+
+// newSkipReport returns a new skipReport writing to the named file,
+// or only counting if file is empty.
+func newSkipReport(file string) (*skipReport, error) {
+	r := &skipReport{counts: make(map[index.SkipReason]int)}
+	if file != "" {
+		f, err := os.Create(file)
+		if err != nil {
+			return nil, err
+		}
+		r.file = f
+		r.w = bufio.NewWriter(f)
+		r.enc = json.NewEncoder(r.w)
+		r.enc.SetEscapeHTML(false)
+	}
+	return r, nil
+}
+
+// add records a skipped file. It is suitable for IndexWriter.Skipped.
+func (r *skipReport) add(name string, reason index.SkipReason, size int64) {
+	r.counts[reason]++
+	if r.enc != nil {
+		r.enc.Encode(skipRecord{name, reason.String(), size})
+	}
+}
+
+// close logs the counts and finishes writing the report.
+func (r *skipReport) close() error {
+	total := 0
+	var counts []string
+	for _, reason := range slices.Sorted(maps.Keys(r.counts)) {
+		total += r.counts[reason]
+		counts = append(counts, fmt.Sprintf("%d %v", r.counts[reason], reason))
+	}
+	if total > 0 {
+		log.Printf("skipped %d files: %s", total, strings.Join(counts, ", "))
+	}
+	if r.file == nil {
+		return nil
+	}
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
@@ -5,14 +5,12 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"runtime/pprof"
-	"strings"
 
 	"github.com/google/codesearch/index"
 	"github.com/google/codesearch/regexp"
@@ -39,8 +37,21 @@ where path... is a list of directories or individual files to be included in the
 If no index exists, this command creates one.  If an index already exists, cindex
 overwrites it.  Run cindex -help for more.
 
-Csearch uses the index stored in $CSEARCHINDEX or, if that variable is unset or
-empty, $HOME/.csearchindex.
+Csearch uses the index stored in $CSEARCHINDEX. If that variable is unset or
+empty, csearch looks for a project index, a file named .csearchindex, in the
+current directory and then in each parent directory, using the first one it
+finds (see cindex -init). Failing that, it uses $HOME/.csearchindex.
+
+The index may instead be a manifest listing several index files, called shards,
+each covering different paths. A manifest is a text file whose first line is
+"csearch manifest" and whose remaining lines name the shards, one per line,
+relative to the directory containing the manifest. Csearch searches all the
+shards as if they were a single index.
+
+$CSEARCHINDEX may also list several indexes (or manifests), separated by
+colons (semicolons on Windows), to search them all together. The results
+are printed in file name order. If more than one of the indexes covers a
+path, the one listed first is used for the files under that path.
 `
 
 func usage() {
@@ -109,84 +120,43 @@ func Main() {
 		log.Printf("query: %s\n", q)
 	}
 
-	ix := index.Open(index.File())
+	ix := index.OpenSetList(index.Files())
 	ix.Verbose = *verboseFlag
-	var post []int
 	if *bruteFlag {
-		post = ix.PostingQuery(&index.Query{Op: index.QAll})
-	} else {
-		post = ix.PostingQuery(q)
-	}
-	if *verboseFlag {
-		log.Printf("post query identified %d possible files\n", len(post))
-	}
-
-	if fre != nil {
-		fnames := make([]int, 0, len(post))
-
-		for _, fileid := range post {
-			name := ix.Name(fileid)
-			if fre.MatchString(name.String(), true, true) < 0 {
-				continue
-			}
-			fnames = append(fnames, fileid)
-		}
-
-		if *verboseFlag {
-			log.Printf("filename regexp matched %d files\n", len(fnames))
-		}
-		post = fnames
+		q = &index.Query{Op: index.QAll}
 	}
 
 	var (
-		zipFile   string
-		zipReader *zip.ReadCloser
-		zipMap    map[string]*zip.File
+		files index.Opener // opens files in archives and git repositories too
+		npost int
+		nfile int
 	)
+	defer files.Close()
 
-	for _, fileid := range post {
+	for fileid := range ix.PostingQuerySeq(q) {
+		npost++
 		name := ix.Name(fileid).String()
+		if fre != nil && fre.MatchString(name, true, true) < 0 {
+			continue
+		}
+		nfile++
 		if g.L && (pat == "(?m)" || pat == "(?i)(?m)") {
 			g.Reader(bytes.NewReader(nil), name)
 			continue
 		}
-		file, err := os.Open(string(name))
+		file, err := files.Open(name)
 		if err != nil {
-			if i := strings.Index(name, ".zip\x01"); i >= 0 {
-				zfile, zname := name[:i+4], name[i+5:]
-				if zfile != zipFile {
-					if zipReader != nil {
-						zipReader.Close()
-						zipMap = nil
-					}
-					zipFile = zfile
-					zipReader, err = zip.OpenReader(zfile)
-					if err != nil {
-						zipReader = nil
-					}
-					if zipReader != nil {
-						zipMap = make(map[string]*zip.File)
-						for _, file := range zipReader.File {
-							zipMap[file.Name] = file
-						}
-					}
-				}
-				file := zipMap[zname]
-				if file != nil {
-					r, err := file.Open()
-					if err != nil {
-						continue
-					}
-					g.Reader(r, name)
-					r.Close()
-					continue
-				}
-			}
 			continue
 		}
 		g.Reader(file, name)
 		file.Close()
 	}
+	if *verboseFlag {
+		log.Printf("post query identified %d possible files\n", npost)
+		if fre != nil {
+			log.Printf("filename regexp matched %d files\n", nfile)
+		}
+	}
 
 	matches = g.Match
 }
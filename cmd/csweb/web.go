@@ -5,12 +5,12 @@
 package main
 
 import (
-	"archive/zip"
 	"bytes"
 	"embed"
 	"flag"
 	"fmt"
 	"html"
+	"io"
 	"io/fs"
 	"log"
 	"net/http"
@@ -23,6 +23,7 @@ import (
 
 	"github.com/google/codesearch/index"
 	"github.com/google/codesearch/regexp"
+	"github.com/google/codesearch/transcode"
 )
 
 var (
@@ -75,79 +76,42 @@ func home(w http.ResponseWriter, r *http.Request) {
 	}
 
 	start := time.Now()
-	ix := index.Open(index.File())
+	ix := index.OpenSetList(index.Files())
 	ix.Verbose = *verboseFlag
-	post := ix.PostingQuery(q)
-	if *verboseFlag {
-		fmt.Fprintf(w, "post query identified %d possible files\n", len(post))
-	}
-
-	if fre != nil {
-		fnames := make([]int, 0, len(post))
-
-		for _, fileid := range post {
-			name := ix.Name(fileid)
-			if fre.MatchString(name.String(), true, true) < 0 {
-				continue
-			}
-			fnames = append(fnames, fileid)
-		}
-
-		if *verboseFlag {
-			fmt.Fprintf(w, "filename regexp matched %d files\n", len(fnames))
-		}
-		post = fnames
-	}
 
 	var (
-		zipFile   string
-		zipReader *zip.ReadCloser
-		zipMap    map[string]*zip.File
+		files index.Opener // opens files in archives and git repositories too
+		npost int
+		nfile int
 	)
+	defer files.Close()
 
-	for _, fileid := range post {
+	// The posting lists are read only as far as needed
+	// to reach the match limit.
+	for fileid := range ix.PostingQuerySeq(q) {
 		if g.Limited {
 			break
 		}
+		npost++
 		name := ix.Name(fileid).String()
-		file, err := os.Open(name)
+		if fre != nil && fre.MatchString(name, true, true) < 0 {
+			continue
+		}
+		nfile++
+		file, err := files.Open(name)
 		if err != nil {
-			if i := strings.Index(name, ".zip\x01"); i >= 0 {
-				zfile, zname := name[:i+4], name[i+5:]
-				if zfile != zipFile {
-					if zipReader != nil {
-						zipReader.Close()
-						zipMap = nil
-					}
-					zipFile = zfile
-					zipReader, err = zip.OpenReader(zfile)
-					if err != nil {
-						zipReader = nil
-					}
-					if zipReader != nil {
-						zipMap = make(map[string]*zip.File)
-						for _, file := range zipReader.File {
-							zipMap[file.Name] = file
-						}
-					}
-				}
-				file := zipMap[zname]
-				if file != nil {
-					r, err := file.Open()
-					if err != nil {
-						continue
-					}
-					g.Reader(r, name)
-					r.Close()
-					continue
-				}
-			}
 			continue
 		}
 		g.Reader(file, name)
 		file.Close()
 	}
 
+	if *verboseFlag {
+		fmt.Fprintf(w, "post query identified %d possible files\n", npost)
+		if fre != nil {
+			fmt.Fprintf(w, "filename regexp matched %d files\n", nfile)
+		}
+	}
 	fmt.Fprintf(w, "\n%d matches in %.3fs\n", g.Matches, time.Since(start).Seconds())
 	if g.Limited {
 		fmt.Fprintf(w, "more matches not shown due to match limit\n")
@@ -177,14 +141,9 @@ func show(w http.ResponseWriter, r *http.Request) {
 		file = file[1:]
 	}
 	// TODO maybe trim file by ix.roots
-	// TODO zips
-	info, err := os.Stat(file)
-	if err != nil {
-		// TODO
-		http.Error(w, err.Error(), 500)
-		return
-	}
-	if info.IsDir() {
+	// Files in archives and git repositories do not exist as such;
+	// the Opener finds them.
+	if info, err := os.Stat(file); err == nil && info.IsDir() {
 		dirs, err := os.ReadDir(file)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
@@ -194,7 +153,16 @@ func show(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data, err := os.ReadFile(file)
+	var files index.Opener
+	defer files.Close()
+	f, err := files.Open(file)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	defer f.Close()
+	// Show the text as indexed and searched, decoded to UTF-8.
+	data, err := io.ReadAll(transcode.NewReader(f))
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
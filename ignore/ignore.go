@@ -0,0 +1,263 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ignore implements gitignore-style rules for skipping
+// files and directories while walking a file tree.
+//
+// The rules follow the gitignore(5) pattern format. Blank lines and
+// lines beginning with # are ignored. A pattern beginning with ! negates
+// the pattern, re-including a path that an earlier rule excluded.
+// A pattern ending with / matches only directories. A pattern
+// containing a / anywhere but at the end is anchored: it is matched
+// against the path relative to the directory containing the ignore
+// file; otherwise it is matched against the final element of the path
+// at any depth. In patterns, * matches any sequence of characters other
+// than /, ? matches any single character other than /, [...] matches
+// a character class (negated with [!...] or [^...]), and \ quotes the
+// next character. A ** element matches zero or more directories.
+// When several rules match a path, the last one wins.
+package ignore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A Rule is a single ignore pattern.
+type Rule struct {
+	Pattern string // pattern as written in the file
+	Source  string // file the rule was read from
+	Line    int    // line number in Source
+	Dir     string // slash-separated directory the rule applies within, relative to the root; "" for the root
+
+	negate   bool     // pattern began with !
+	dirOnly  bool     // pattern ended with /
+	anchored bool     // pattern is matched against the whole relative path
+	elems    []string // pattern split at slashes
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("%s:%d: %s", r.Source, r.Line, r.Pattern)
+}
+
+// Parse parses the ignore rules in data, which was read from
+// the file named source, in the directory dir relative to the root.
+// Lines that are not valid patterns are ignored.
+func Parse(data []byte, source, dir string) []*Rule {
+	var rules []*Rule
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		r := parseRule(string(bytes.TrimSuffix(line, []byte("\r"))))
+		if r == nil {
+			continue
+		}
+		r.Source = source
+		r.Line = i + 1
+		r.Dir = dir
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// ReadFile reads and parses the ignore rules in file,
+// which is in the directory dir relative to the root.
+func ReadFile(file, dir string) ([]*Rule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, file, dir), nil
+}
+
+// parseRule parses a single line of an ignore file,
+// returning nil for blank lines, comments and invalid patterns.
+func parseRule(line string) *Rule {
+	// Trailing spaces are ignored unless quoted with a backslash.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	r := &Rule{Pattern: line}
+	p := line
+	if p[0] == '!' {
+		r.negate = true
+		p = p[1:]
+	}
+	if strings.HasSuffix(p, "/") {
+		r.dirOnly = true
+		p = p[:len(p)-1]
+	}
+	if strings.Contains(p, "/") {
+		r.anchored = true
+		p = strings.TrimPrefix(p, "/")
+	}
+	if p == "" {
+		return nil
+	}
+	r.elems = strings.Split(p, "/")
+	for i, elem := range r.elems {
+		elem = negateClass(elem)
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil
+		}
+		r.elems[i] = elem
+	}
+	return r
+}
+
+// negateClass rewrites the negated character classes [!...] in pattern
+// to the [^...] form that path.Match expects.
+func negateClass(pattern string) string {
+	b := []byte(pattern)
+	for i := 0; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '[':
+			if i+1 < len(b) && b[i+1] == '!' {
+				b[i+1] = '^'
+			}
+		}
+	}
+	return string(b)
+}
+
+// Match reports whether r matches name, a slash-separated path
+// relative to r.Dir. The path names a directory if isDir is true.
+func (r *Rule) Match(name string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := path.Match(r.elems[0], path.Base(name))
+		return ok
+	}
+	return matchElems(r.elems, strings.Split(name, "/"))
+}
+
+// matchElems reports whether the pattern elements pat match
+// the path elements name.
+func matchElems(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				// A trailing ** matches everything inside.
+				return len(name) > 0
+			}
+			for i := range len(name) + 1 {
+				if matchElems(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// Match reports whether rules ignore name, a slash-separated path
+// relative to the root. The path names a directory if isDir is true.
+// Later rules take precedence over earlier ones, and a rule applies
+// only to paths within its directory.
+func Match(rules []*Rule, name string, isDir bool) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		r := rules[i]
+		rel := name
+		if r.Dir != "" {
+			var ok bool
+			if rel, ok = strings.CutPrefix(name, r.Dir+"/"); !ok {
+				continue
+			}
+		}
+		if r.Match(rel, isDir) {
+			return !r.negate
+		}
+	}
+	return false
+}
+
+// A Walker tracks the ignore rules in effect during a depth-first walk
+// of a file tree, such as one by filepath.Walk, reading the ignore files
+// in each directory as the walk enters it.
+type Walker struct {
+	// Load, if non-nil, is called after reading each ignore file,
+	// with the rules read or the error reading the file.
+	Load func(file string, rules []*Rule, err error)
+
+	root  string
+	files []string
+	rules []*Rule // global rules, then rules from enclosing directories
+	base  int     // number of global rules
+}
+
+// NewWalker returns a Walker for the tree rooted at root.
+// The global rules apply to the whole tree. In each directory,
+// the Walker reads the ignore files with the given names,
+// in increasing order of precedence.
+func NewWalker(root string, global []*Rule, files ...string) *Walker {
+	return &Walker{
+		root:  root,
+		files: files,
+		rules: global,
+		base:  len(global),
+	}
+}
+
+// Skip reports whether the walk should skip the file or directory
+// named path, which is a directory if isDir is true. The paths must be
+// the root or paths within it, in the order of a depth-first walk.
+// When Skip returns false for a directory, it reads that directory's
+// ignore files, which apply to the paths within it.
+func (w *Walker) Skip(path string, isDir bool) bool {
+	rel, err := filepath.Rel(w.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+
+	// Drop the rules for directories the walk has left.
+	for len(w.rules) > w.base {
+		dir := w.rules[len(w.rules)-1].Dir
+		if dir == "" || strings.HasPrefix(rel, dir+"/") {
+			break
+		}
+		w.rules = w.rules[:len(w.rules)-1]
+	}
+
+	if rel != "" && Match(w.rules, rel, isDir) {
+		return true
+	}
+	if isDir {
+		for _, name := range w.files {
+			file := filepath.Join(path, name)
+			rules, err := ReadFile(file, rel)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if w.Load != nil {
+				w.Load(file, rules, err)
+			}
+			w.rules = append(w.rules, rules...)
+		}
+	}
+	return false
+}
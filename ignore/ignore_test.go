@@ -0,0 +1,154 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var matchTests = []struct {
+	rules string
+	name  string
+	isDir bool
+	want  bool
+}{
+	{"*.o", "a.o", false, true},
+	{"*.o", "x/y/a.o", false, true},
+	{"*.o", "a.out", false, false},
+	{"# comment\n\n*.o", "# comment", false, false},
+	{`\#x`, "#x", false, true},
+	{`\!x`, "!x", false, true},
+	{"x  ", "x", false, true},
+	{`x\ `, "x ", false, true},
+	{`x\ `, "x", false, false},
+	{"build/", "build", true, true},
+	{"build/", "build", false, false},
+	{"build/", "src/build", true, true},
+	{"/build", "build", false, true},
+	{"/build", "src/build", false, false},
+	{"src/gen", "src/gen", true, true},
+	{"src/gen", "x/src/gen", true, false},
+	{"src/*.pb.go", "src/a.pb.go", false, true},
+	{"src/*.pb.go", "src/x/a.pb.go", false, false},
+	{"**/gen", "gen", true, true},
+	{"**/gen", "a/b/gen", true, true},
+	{"a/**/b", "a/b", false, true},
+	{"a/**/b", "a/x/y/b", false, true},
+	{"a/**/b", "x/a/b", false, false},
+	{"a/**", "a/x/y", false, true},
+	{"a/**", "a", true, false},
+	{"file?.txt", "file1.txt", false, true},
+	{"file?.txt", "file10.txt", false, false},
+	{"[abc].go", "b.go", false, true},
+	{"[!abc].go", "b.go", false, false},
+	{"[!abc].go", "d.go", false, true},
+	{"*.log\n!keep.log", "keep.log", false, false},
+	{"*.log\n!keep.log", "other.log", false, true},
+	{"!keep.log\n*.log", "keep.log", false, true},
+	{"[", "[", false, false},
+}
+
+func TestMatch(t *testing.T) {
+	for _, tt := range matchTests {
+		rules := Parse([]byte(tt.rules), "test", "")
+		if have := Match(rules, tt.name, tt.isDir); have != tt.want {
+			t.Errorf("Match(%q, %q, %v) = %v, want %v", tt.rules, tt.name, tt.isDir, have, tt.want)
+		}
+	}
+}
+
+func TestMatchDir(t *testing.T) {
+	rules := Parse([]byte("/x\n*.tmp"), "sub/.gitignore", "sub")
+	for _, tt := range []struct {
+		name string
+		want bool
+	}{
+		{"sub/x", true},
+		{"sub/y/x", false},
+		{"x", false},
+		{"sub/a.tmp", true},
+		{"a.tmp", false},
+		{"subway/a.tmp", false},
+	} {
+		if have := Match(rules, tt.name, false); have != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.name, have, tt.want)
+		}
+	}
+}
+
+func TestWalker(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":          "*.o\nbuild/\n/top.txt\n",
+		".csearchignore":      "!keep.o\n",
+		"a.o":                 "",
+		"keep.o":              "",
+		"top.txt":             "",
+		"a/top.txt":           "",
+		"a/b.o":               "",
+		"a/build/x.go":        "",
+		"gen/.gitignore":      "*.go\n!main.go\n",
+		"gen/main.go":         "",
+		"gen/x.go":            "",
+		"gen/sub/y.go":        "",
+		"gen2/x.go":           "",
+		"node_modules/m/m.js": "",
+		"src/z.go":            "",
+	}
+	for name, data := range files {
+		file := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(file), 0777)
+		if err := os.WriteFile(file, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	global := Parse([]byte("node_modules/\n"), "global", "")
+	w := NewWalker(root, global, ".gitignore", ".csearchignore")
+	var loaded []string
+	w.Load = func(file string, rules []*Rule, err error) {
+		if err != nil {
+			t.Error(err)
+		}
+		rel, _ := filepath.Rel(root, file)
+		loaded = append(loaded, filepath.ToSlash(rel))
+	}
+	var have []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Skip(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			rel, _ := filepath.Rel(root, path)
+			have = append(have, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	want := []string{
+		"a/top.txt",
+		"gen/main.go",
+		"gen2/x.go",
+		"keep.o",
+		"src/z.go",
+	}
+	if !slices.Equal(have, want) {
+		t.Errorf("walk found %q, want %q", have, want)
+	}
+	wantLoaded := []string{".gitignore", ".csearchignore", "gen/.gitignore"}
+	if !slices.Equal(loaded, wantLoaded) {
+		t.Errorf("loaded %q, want %q", loaded, wantLoaded)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Built-in extractors.
+//
+// The built-in extractors read zip files (.zip), tar files (.tar),
+// compressed tar files (.tar.gz and .tgz), and gzip-compressed files
+// (.gz), which hold a single member named for the file without its
+// .gz suffix. See extract.go for how they are used.
+
+func init() {
+	RegisterExtractor("zip", ExtractorFunc(openZip), ".zip")
+	RegisterExtractor("tar", ExtractorFunc(openTar), ".tar")
+	RegisterExtractor("tgz", ExtractorFunc(openTarGzip), ".tar.gz", ".tgz")
+	RegisterExtractor("gzip", ExtractorFunc(openGzip), ".gz")
+}
+
+// A memberList is an Archive holding a list of members
+// and a function to open each one.
+type memberList struct {
+	members []Member
+	open    map[string]func() (io.ReadCloser, error)
+	close   func() error // nil if there is nothing to release
+}
+
+// add adds a member to the list, replacing any member with the same name.
+func (a *memberList) add(m Member, open func() (io.ReadCloser, error)) {
+	if a.open == nil {
+		a.open = make(map[string]func() (io.ReadCloser, error))
+	}
+	if _, ok := a.open[m.Name]; ok {
+		for i := range a.members {
+			if a.members[i].Name == m.Name {
+				a.members[i] = m
+			}
+		}
+	} else {
+		a.members = append(a.members, m)
+	}
+	a.open[m.Name] = open
+}
+
+func (a *memberList) Members() []Member { return a.members }
+
+func (a *memberList) Open(name string) (io.ReadCloser, error) {
+	open := a.open[name]
+	if open == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return open()
+}
+
+func (a *memberList) Close() error {
+	if a.close != nil {
+		return a.close()
+	}
+	return nil
+}
+
+// readerAt returns f as an io.ReaderAt, along with its size,
+// if f is a regular file that can be read at random.
+func readerAt(f io.Reader) (io.ReaderAt, int64, bool) {
+	ra, ok := f.(interface {
+		io.ReaderAt
+		Stat() (os.FileInfo, error)
+	})
+	if !ok {
+		return nil, 0, false
+	}
+	info, err := ra.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, 0, false
+	}
+	return ra, info.Size(), true
+}
+
+func openZip(name string, f io.Reader) (Archive, error) {
+	ra, size, ok := readerAt(f)
+	if !ok {
+		return nil, ErrNotArchive
+	}
+	r, err := zip.NewReader(ra, size)
+	if err != nil {
+		return nil, err
+	}
+	a := new(memberList)
+	for _, file := range r.File {
+		if strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		a.add(Member{file.Name, file.Modified}, file.Open)
+	}
+	return a, nil
+}
+
+func openTar(name string, f io.Reader) (Archive, error) {
+	ra, size, ok := readerAt(f)
+	if !ok {
+		return nil, ErrNotArchive
+	}
+	return readTar(ra, size)
+}
+
+// openTarGzip opens a compressed tar file
+// by decompressing it to a temporary file.
+func openTarGzip(name string, f io.Reader) (Archive, error) {
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "csearch-tar-")
+	if err != nil {
+		return nil, err
+	}
+	remove := func() error {
+		err := tmp.Close()
+		return errors.Join(err, os.Remove(tmp.Name()))
+	}
+	size, err := io.Copy(tmp, zr)
+	if err != nil {
+		remove()
+		return nil, err
+	}
+	a, err := readTar(tmp, size)
+	if err != nil {
+		remove()
+		return nil, err
+	}
+	a.close = remove
+	return a, nil
+}
+
+// readTar reads the list of regular files in the tar file ra.
+// If the archive holds several files with the same name,
+// the last one is the one that counts, as when extracting it.
+func readTar(ra io.ReaderAt, size int64) (*memberList, error) {
+	// The tar reader does no buffering, so after it reads a header,
+	// the offset of the underlying reader is that of the file data.
+	cr := &countReader{r: io.NewSectionReader(ra, 0, size)}
+	tr := tar.NewReader(cr)
+	a := new(memberList)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		off, size := cr.n, hdr.Size
+		a.add(Member{hdr.Name, hdr.ModTime}, func() (io.ReadCloser, error) {
+			return io.NopCloser(io.NewSectionReader(ra, off, size)), nil
+		})
+	}
+	return a, nil
+}
+
+// A countReader counts the bytes read from r.
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// openGzip opens a gzip-compressed file as an archive
+// holding the decompressed file.
+func openGzip(name string, f io.Reader) (Archive, error) {
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	mtime := zr.ModTime
+	if mtime.IsZero() {
+		mtime = modTime(f)
+	}
+	open := func() (io.ReadCloser, error) {
+		if zr == nil {
+			return nil, fmt.Errorf("%s: cannot reread", name)
+		}
+		r := zr
+		zr = nil
+		return r, nil
+	}
+	if ra, size, ok := readerAt(f); ok {
+		open = func() (io.ReadCloser, error) {
+			return gzip.NewReader(io.NewSectionReader(ra, 0, size))
+		}
+	}
+	elem := strings.TrimSuffix(path.Base(strings.ReplaceAll(name, "\\", "/")), ".gz")
+	a := new(memberList)
+	a.add(Member{elem, mtime}, open)
+	return a, nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// archiveMembers are the files in the test archives, out of order.
+var archiveMembers = []string{
+	"b/yy", "first potatoes, now liberty?",
+	"a/x", "hello world",
+	"cc", "come to the aid of his potatoes",
+	"a/y", "goodbye world",
+	"a/x", "hello again, world", // replaces the first a/x in a tar file
+	"c/ab", "give me all the potatoes",
+}
+
+func makeTar(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	w.WriteHeader(&tar.Header{Name: "a/", Typeflag: tar.TypeDir, Mode: 0777})
+	for i := 0; i < len(archiveMembers); i += 2 {
+		data := archiveMembers[i+1]
+		hdr := &tar.Header{Name: archiveMembers[i], Mode: 0666, Size: int64(len(data)), ModTime: time.Unix(1e9, 0)}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(data))
+	}
+	w.WriteHeader(&tar.Header{Name: "link", Linkname: "cc", Typeflag: tar.TypeSymlink})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipData(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for i := 0; i < len(archiveMembers)-4; i += 2 {
+		ww, err := w.Create(archiveMembers[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		ww.Write([]byte(archiveMembers[i+1]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestArchives(t *testing.T) {
+	dir := t.TempDir()
+	tarData := makeTar(t)
+	files := map[string][]byte{
+		"src.tar":    tarData,
+		"src.tar.gz": gzipData(t, tarData),
+		"src.tgz":    gzipData(t, tarData),
+		"x.c.gz":     gzipData(t, []byte("int potatoes;\n")),
+		"z.zip":      makeZip(t),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := filepath.Join(t.TempDir(), "index")
+	ix := Create(out)
+	ix.Zip = true
+	ix.AddRoots([]Path{MakePath(dir)})
+	for _, name := range []string{"src.tar", "src.tar.gz", "src.tgz", "x.c.gz", "z.zip"} {
+		if err := ix.AddFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix.Flush()
+
+	rx := Open(out)
+	if err := rx.Check(); err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, name := range []string{"src.tar", "src.tar.gz", "src.tgz"} {
+		for _, m := range []string{"a/x", "a/y", "b/yy", "c/ab", "cc"} {
+			want = append(want, filepath.Join(dir, name)+"\x01"+m)
+		}
+	}
+	want = append(want, filepath.Join(dir, "x.c.gz")+"\x01x.c")
+	for _, m := range []string{"a/x", "a/y", "b/yy", "cc"} {
+		want = append(want, filepath.Join(dir, "z.zip")+"\x01"+m)
+	}
+	checkFiles(t, rx, want...)
+	checkPosting(t, rx, "aga", 0, 5, 10)
+	checkPosting(t, rx, "pot", 2, 3, 4, 7, 8, 9, 12, 13, 14, 15, 18, 19)
+	if m, _ := rx.Meta(0); !m.ModTime.Equal(time.Unix(1e9, 0)) {
+		t.Errorf("Meta(0).ModTime = %v, want %v", m.ModTime, time.Unix(1e9, 0))
+	}
+
+	// An Opener reads the members back.
+	contents := map[string]string{"x.c": "int potatoes;\n"}
+	for i := 0; i < len(archiveMembers); i += 2 {
+		contents[archiveMembers[i]] = archiveMembers[i+1]
+	}
+	var o Opener
+	defer o.Close()
+	for id := range rx.numName {
+		name := rx.Name(id).String()
+		r, err := o.Open(name)
+		if err != nil {
+			t.Errorf("Open(%q): %v", name, err)
+			continue
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		_, elem, _ := strings.Cut(name, "\x01")
+		wantData := contents[elem]
+		if id >= 16 && elem == "a/x" {
+			wantData = "hello world" // the zip file has only the first a/x
+		}
+		if err != nil || string(data) != wantData {
+			t.Errorf("Open(%q) read %q, %v, want %q", name, data, err, wantData)
+		}
+	}
+	if _, err := o.Open(filepath.Join(dir, "src.tgz") + "\x01nope"); !os.IsNotExist(err) {
+		t.Errorf("Open(missing member) error = %v, want not exist", err)
+	}
+
+	// Merging keeps the members in order.
+	out2 := filepath.Join(t.TempDir(), "index2")
+	buildIndex(out2, []string{"/a"}, map[string]string{"/a/x": "hello"})
+	out3 := filepath.Join(t.TempDir(), "index3")
+	Merge(out3, out2, out)
+	want = append([]string{"/a/x"}, want...)
+	checkFiles(t, Open(out3), want...)
+}
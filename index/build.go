@@ -0,0 +1,341 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Building indexes.
+//
+// Build does everything needed to add file trees to an index or to
+// reindex them: it walks the trees, writes an index of their files to
+// the temporary file dst~, merges that with the existing index dst, if
+// any, and renames the result to dst. Until that final rename, dst is
+// untouched, so a Build that fails or is canceled leaves the existing
+// index as it was. The cindex command is a thin wrapper around Build.
+//
+// Build returns errors instead of exiting, including errors creating
+// or writing the new index, but like the rest of the package it still
+// stops the program when the existing index is corrupt.
+
+// Options control Build.
+type Options struct {
+	Reset   bool // discard the existing index instead of adding to it
+	Full    bool // reread unchanged files instead of reusing the existing index data
+	Check   bool // check the existing and new indexes for corruption
+	Verbose bool // log status using package log
+	Workers int  // number of files to read at once; see IndexWriter.Workers
+
+	// Root, if non-nil, is called before Build indexes each root to say
+	// how to index it, given the options recorded for the root in the
+	// existing index, or nil if there are none. If Root returns an error,
+	// Build stops and returns it. If Root is nil, Build walks each root
+	// with the zero RootConfig.
+	Root func(root Path, recorded []string) (*RootConfig, error)
+
+	// Progress, if non-nil, is called as Build makes progress.
+	Progress func(Progress)
+
+	// Skipped, if non-nil, is called for each file that is not indexed,
+	// as for IndexWriter.Skipped.
+	Skipped func(name string, reason SkipReason, size int64)
+
+	// Error, if non-nil, is called with each error that does not stop
+	// Build, such as an error reading a file or directory. If Error is
+	// nil, Build logs those errors using package log.
+	Error func(error)
+}
+
+// A RootConfig says how Build indexes a root.
+type RootConfig struct {
+	// Options are the options to record for the root.
+	// See IndexWriter.SetRootOptions.
+	Options []string
+
+	// Zip, Limits, LimitOverrides and Partial set the
+	// IndexWriter fields of the same names for the root's files.
+	Zip            bool
+	Limits         Limits
+	LimitOverrides []LimitOverride
+	Partial        bool
+
+	// Follow causes Build to follow symbolic links while walking the root,
+	// indexing each file or directory only once, at the first path by
+	// which the walk reaches it, and stopping at links that lead back to
+	// a directory already walked.
+	Follow bool
+
+	// Canonical, along with Follow, causes Build to index each file
+	// under its canonical name: its path with links resolved, relative
+	// to the root with links resolved. A file whose canonical path is
+	// outside the root keeps the path by which the walk reached it.
+	Canonical bool
+
+	// Skip, if non-nil, is called for each file and directory in the
+	// walk, in the order the walk reaches them, and reports whether to
+	// leave it out, along with everything in it. Build always leaves out
+	// the files and directories for which IsHidden reports true.
+	Skip func(path string, isDir bool) bool
+
+	// Add, if non-nil, adds the root's files to the index instead of
+	// walking the root, as when the files come from a list or from a
+	// git repository. It is passed the context given to Build and
+	// should stop and return ctx.Err() if ctx is canceled. Build reports
+	// any other error it returns as for an error reading a file.
+	Add func(ctx context.Context, ix *IndexWriter) error
+}
+
+// A Stage is a stage of Build.
+type Stage int
+
+const (
+	StageRoot  Stage = iota // starting to index Progress.Root
+	StageFile               // added Progress.File
+	StageFlush              // writing the new index
+	StageMerge              // merging it with the existing index
+	StageDone               // finished
+)
+
+// A Progress describes the progress of Build.
+type Progress struct {
+	Stage Stage
+	Root  Path   // root being indexed, for StageRoot and StageFile
+	File  string // file just added, for StageFile
+	Files int    // number of files added by walking the roots so far
+}
+
+// IsHidden reports whether the file or directory with the given name,
+// a final path element, is a temporary or "hidden" one, which Build
+// does not index: one whose name begins with '.', '#' or '~' or ends
+// with '~'.
+func IsHidden(name string) bool {
+	return name != "" && (name[0] == '.' || name[0] == '#' || name[0] == '~' || name[len(name)-1] == '~')
+}
+
+// Build indexes the given roots, adding them to the index in the file
+// dst, or replacing them if the index already has them. If roots is nil,
+// Build reindexes the roots the index already has.
+// If ctx is canceled, Build stops and returns ctx.Err().
+func Build(ctx context.Context, dst string, roots []Path, opts Options) error {
+	if IsManifest(dst) {
+		return fmt.Errorf("%s is a shard manifest; build each shard instead", dst)
+	}
+	report := opts.Error
+	if report == nil {
+		report = func(err error) { log.Print(err) }
+	}
+	progress := func(p Progress) {
+		if opts.Progress != nil {
+			opts.Progress(p)
+		}
+	}
+
+	var old *Index
+	if !opts.Reset {
+		if _, err := os.Stat(dst); err == nil {
+			old = Open(dst)
+			defer old.close()
+			if opts.Check {
+				if err := old.Check(); err != nil {
+					return fmt.Errorf("%s: %v", dst, err)
+				}
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if roots == nil {
+		if old == nil {
+			return fmt.Errorf("%s: no index to update", dst)
+		}
+		roots = slices.Collect(old.Roots().All())
+	}
+	roots = slices.Clone(roots)
+	slices.SortFunc(roots, Path.Compare)
+	roots = slices.Compact(roots)
+
+	file := dst + "~"
+	ix, err := create(file)
+	if err != nil {
+		return err
+	}
+	ix.Verbose = opts.Verbose
+	ix.Skipped = opts.Skipped
+	ix.Workers = opts.Workers
+	if old != nil && !opts.Full {
+		ix.Reuse(old)
+	}
+	ix.AddRoots(roots)
+	files := 0
+	for _, root := range roots {
+		if err := ctx.Err(); err != nil {
+			ix.abort()
+			return err
+		}
+		progress(Progress{Stage: StageRoot, Root: root, Files: files})
+		var recorded []string
+		if old != nil {
+			recorded = old.RootOptions(root)
+		}
+		cfg := new(RootConfig)
+		if opts.Root != nil {
+			var err error
+			if cfg, err = opts.Root(root, recorded); err != nil {
+				ix.abort()
+				return err
+			}
+		}
+		// Record an empty list rather than none, so that
+		// the merge does not keep the old options.
+		ix.SetRootOptions(root, append([]string{}, cfg.Options...))
+		ix.Zip = cfg.Zip
+		ix.Limits = cfg.Limits
+		ix.LimitOverrides = cfg.LimitOverrides
+		ix.Partial = cfg.Partial
+		if cfg.Add != nil {
+			if err := cfg.Add(ctx, ix); err != nil {
+				if err := ctx.Err(); err != nil {
+					ix.abort()
+					return err
+				}
+				report(err)
+			}
+			continue
+		}
+		err := walkRoot(ctx, root.String(), cfg, report, func(name string) {
+			if err := ix.AddFile(name); err != nil {
+				report(fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			files++
+			progress(Progress{Stage: StageFile, Root: root, File: name, Files: files})
+		})
+		if err != nil {
+			ix.abort()
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		ix.abort()
+		return err
+	}
+	progress(Progress{Stage: StageFlush, Files: files})
+	if err := ix.flush(); err != nil {
+		ix.abort()
+		return err
+	}
+
+	if old != nil {
+		progress(Progress{Stage: StageMerge, Files: files})
+		built := Open(file)
+		err := merge(file+"~", old, built)
+		built.close()
+		os.Remove(file)
+		file += "~"
+		if err != nil {
+			os.Remove(file)
+			return err
+		}
+	}
+	if opts.Check {
+		built := Open(file)
+		err := built.Check()
+		built.close()
+		if err != nil {
+			os.Remove(file)
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	if err := os.Rename(file, dst); err != nil {
+		os.Remove(file)
+		return err
+	}
+	progress(Progress{Stage: StageDone, Files: files})
+	return nil
+}
+
+// walkRoot walks the file tree rooted at root as cfg says,
+// calling add with the name of each file to index, in index order.
+// The walk finds the files in that order, so walkRoot adds each one as
+// it finds it, except that with canonical names it sorts them first.
+func walkRoot(ctx context.Context, root string, cfg *RootConfig, report func(error), add func(name string)) error {
+	walk := filepath.Walk
+	if cfg.Follow {
+		walk = walkFollow
+	}
+	canonical := cfg.Follow && cfg.Canonical
+	var names []string         // with canonical names, the files to rename
+	seen := make(map[any]bool) // files found by following links, by fileKey
+	err := walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, elem := filepath.Split(path); IsHidden(elem) {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if err != nil {
+			report(err)
+			return nil
+		}
+		if cfg.Skip != nil && cfg.Skip(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode()&os.ModeType == 0 {
+			if cfg.Follow {
+				if key, ok := fileKey(path, info); ok {
+					if seen[key] {
+						return nil
+					}
+					seen[key] = true
+				}
+			}
+			if canonical {
+				names = append(names, path)
+			} else {
+				add(path)
+			}
+		}
+		return nil
+	})
+	if err != nil || !canonical {
+		return err
+	}
+	names = canonicalNames(root, names)
+	slices.SortFunc(names, func(x, y string) int {
+		return MakePath(x).Compare(MakePath(y))
+	})
+	for _, name := range slices.Compact(names) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		add(name)
+	}
+	return nil
+}
+
+// abort abandons the index being written, removing its files.
+func (ix *IndexWriter) abort() {
+	ix.stopWorkers()
+	for _, b := range []*Buffer{ix.nameData, ix.nameIndex, ix.meta, ix.postFile, ix.postIndex, ix.main} {
+		if b == nil {
+			continue // not created
+		}
+		b.file.Close()
+		os.Remove(b.name)
+	}
+}
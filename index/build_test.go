@@ -0,0 +1,225 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/a/x.c":       "int apple;\n",
+		"src/a.c":         "int banana;\n",
+		"src/a/.hidden":   "int apple;\n",
+		"src/a/backup~":   "int apple;\n",
+		"src/a/skip/y.c":  "int apple;\n",
+		"src/b/z.c":       "int cherry;\n",
+		"src/b/#tmp#/w.c": "int apple;\n",
+		"other/o.c":       "int apple;\n",
+		"other/.git/x":    "int apple;\n",
+	})
+	src := MakePath(filepath.Join(dir, "src"))
+	other := MakePath(filepath.Join(dir, "other"))
+	out := filepath.Join(t.TempDir(), "index")
+	name := func(s string) string { return filepath.Join(dir, filepath.FromSlash(s)) }
+
+	var stages []Stage
+	var added []string
+	opts := Options{
+		Root: func(root Path, recorded []string) (*RootConfig, error) {
+			return &RootConfig{
+				Options: append(recorded, "-x"),
+				Skip: func(path string, isDir bool) bool {
+					return isDir && filepath.Base(path) == "skip"
+				},
+			}, nil
+		},
+		Progress: func(p Progress) {
+			stages = append(stages, p.Stage)
+			if p.Stage == StageFile {
+				added = append(added, p.File)
+			}
+		},
+	}
+	if err := Build(context.Background(), out, []Path{other}, opts); err != nil {
+		t.Fatal(err)
+	}
+	wantStages := []Stage{StageRoot, StageFile, StageFlush, StageDone}
+	if !slices.Equal(stages, wantStages) {
+		t.Errorf("stages = %v, want %v", stages, wantStages)
+	}
+
+	// Adding roots merges them with the existing index,
+	// passing the options recorded in it.
+	stages = nil
+	added = nil
+	if err := Build(context.Background(), out, []Path{src}, opts); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{name("src/a/x.c"), name("src/a.c"), name("src/b/z.c")}
+	ix := Open(out)
+	checkFiles(t, ix, append([]string{name("other/o.c")}, want...)...)
+	if ix.numName != len(want)+1 {
+		t.Errorf("index has %d files, want %d", ix.numName, len(want)+1)
+	}
+	if !slices.Equal(added, want) {
+		t.Errorf("added %q, want %q", added, want)
+	}
+	wantStages = []Stage{StageRoot, StageFile, StageFile, StageFile, StageFlush, StageMerge, StageDone}
+	if !slices.Equal(stages, wantStages) {
+		t.Errorf("stages = %v, want %v", stages, wantStages)
+	}
+	if opts := ix.RootOptions(src); !slices.Equal(opts, []string{"-x"}) {
+		t.Errorf("RootOptions(%s) = %q, want [-x]", src, opts)
+	}
+
+	// Reindexing with no roots reindexes the existing ones,
+	// using their recorded options.
+	var roots []Path
+	var recorded [][]string
+	opts.Root = func(root Path, rec []string) (*RootConfig, error) {
+		roots = append(roots, root)
+		recorded = append(recorded, rec)
+		return &RootConfig{Options: rec}, nil
+	}
+	opts.Progress = nil
+	if err := Build(context.Background(), out, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(roots, []Path{other, src}) || !slices.EqualFunc(recorded, [][]string{{"-x"}, {"-x"}}, slices.Equal) {
+		t.Errorf("reindexed %v with %q, want [%s %s] with [-x] each", roots, recorded, other, src)
+	}
+	checkFiles(t, Open(out), name("other/o.c"), name("src/a/skip/y.c"), name("src/a/x.c"), name("src/a.c"))
+
+	// A canceled Build leaves the index as it was.
+	before, _ := os.ReadFile(out)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	opts.Root = nil
+	if err := Build(ctx, out, []Path{src}, opts); err != context.Canceled {
+		t.Errorf("canceled Build = %v, want %v", err, context.Canceled)
+	}
+	after, _ := os.ReadFile(out)
+	if string(before) != string(after) {
+		t.Errorf("canceled Build changed index")
+	}
+	temps, _ := filepath.Glob(out + "~*")
+	if len(temps) > 0 {
+		t.Errorf("canceled Build left %q", temps)
+	}
+
+	// So does one canceled while a root's Add hook runs.
+	ctx, cancel = context.WithCancel(context.Background())
+	opts.Root = func(Path, []string) (*RootConfig, error) {
+		return &RootConfig{Add: func(ctx context.Context, ix *IndexWriter) error {
+			cancel()
+			return ctx.Err()
+		}}, nil
+	}
+	if err := Build(ctx, out, []Path{src}, opts); err != context.Canceled {
+		t.Errorf("Build canceled in Add = %v, want %v", err, context.Canceled)
+	}
+	if after, _ := os.ReadFile(out); string(before) != string(after) {
+		t.Errorf("Build canceled in Add changed index")
+	}
+	opts.Root = nil
+
+	// Errors are returned, not fatal.
+	if err := Build(context.Background(), filepath.Join(dir, "none"), nil, opts); err == nil || !strings.Contains(err.Error(), "no index") {
+		t.Errorf("Build of missing index = %v, want no index error", err)
+	}
+	if err := Build(context.Background(), out, []Path{src}, Options{
+		Root: func(Path, []string) (*RootConfig, error) { return nil, os.ErrPermission },
+	}); err != os.ErrPermission {
+		t.Errorf("Build with failing Root = %v, want %v", err, os.ErrPermission)
+	}
+	if err := Build(context.Background(), filepath.Join(dir, "none", "index"), []Path{src}, opts); err == nil {
+		t.Errorf("Build in missing directory succeeded")
+	}
+	if err := os.Mkdir(out+"~", 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := Build(context.Background(), out, []Path{src}, opts); err == nil {
+		t.Errorf("Build with unwritable %s~ succeeded", out)
+	}
+	if after, _ := os.ReadFile(out); string(before) != string(after) {
+		t.Errorf("failed Build changed index")
+	}
+}
+
+func TestBuildChangedOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.c":    "int apple;\n",
+		"long.c": "int banana;\n" + strings.Repeat("x", 3000) + "\n",
+	})
+	root := MakePath(dir)
+	out := filepath.Join(t.TempDir(), "index")
+	long := filepath.Join(dir, "long.c")
+
+	// Each Build reindexes the root with the given options,
+	// rereading the files indexed under different ones.
+	for _, tt := range []struct {
+		cfg     RootConfig
+		skipped bool
+		partial bool
+	}{
+		{RootConfig{Options: []string{"-maxline=-1"}, Limits: Limits{MaxLineLen: -1}}, false, false},
+		{RootConfig{Options: []string{"-partial"}, Partial: true}, false, true},
+		{RootConfig{}, true, false},
+		{RootConfig{Options: []string{"-partial"}, Partial: true}, false, true},
+	} {
+		var skipped []string
+		opts := Options{
+			Root: func(Path, []string) (*RootConfig, error) { return &tt.cfg, nil },
+			Skipped: func(name string, reason SkipReason, size int64) {
+				skipped = append(skipped, name)
+			},
+		}
+		if err := Build(context.Background(), out, []Path{root}, opts); err != nil {
+			t.Fatal(err)
+		}
+		ix := Open(out)
+		if err := ix.Check(); err != nil {
+			t.Fatal(err)
+		}
+		if tt.skipped {
+			if !slices.Equal(skipped, []string{long}) || ix.numName != 1 {
+				t.Errorf("options %q: skipped %q, indexed %d files, want long.c skipped", tt.cfg.Options, skipped, ix.numName)
+			}
+			continue
+		}
+		if len(skipped) > 0 || ix.numName != 2 {
+			t.Errorf("options %q: skipped %q, indexed %d files, want none skipped", tt.cfg.Options, skipped, ix.numName)
+			continue
+		}
+		checkFiles(t, ix, filepath.Join(dir, "a.c"), long)
+		if p := ix.Partial(1); p != tt.partial {
+			t.Errorf("options %q: Partial(long.c) = %v, want %v", tt.cfg.Options, p, tt.partial)
+		}
+		if n := len(ix.PostingList(tri("xxx"))); n != 1 {
+			t.Errorf("options %q: %d files contain xxx, want 1", tt.cfg.Options, n)
+		}
+	}
+}
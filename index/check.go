@@ -40,18 +40,9 @@ func (ix *Index) Check() error {
 		_ = b0
 		for len(b) > 3 && (b[0] != 0 || b[1] != 0 || b[2] != 0) {
 			t := b[:3]
-			trigram := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
-			_ = trigram
-			count, l1 := binary.Uvarint(b[3:])
-			if l1 <= 0 {
-				ix.corrupt()
-			}
-			o, l2 := binary.Uvarint(b[3+l1:])
-			if l2 <= 0 {
-				ix.corrupt()
-			}
-			offset += int(o)
-			b = b[3+l1+l2:]
+			trigram, count, o, enc, l := ix.postBlockEntry(b)
+			offset += o
+			b = b[l:]
 
 			// Read posting list for this trigram.
 			plist := pdata[offset:]
@@ -60,7 +51,7 @@ func (ix *Index) Check() error {
 				ix.corrupt()
 			}
 			var dr deltaReader
-			dr.init(ix, plist[3:])
+			dr.initList(ix, plist[3:], enc, count)
 			for range count {
 				d := dr.next()
 				if d == 0 {
@@ -70,8 +61,43 @@ func (ix *Index) Check() error {
 			if dr.next() != 0 {
 				ix.corrupt()
 			}
+			if enc == encBitmap {
+				// No file IDs may follow the last one.
+				dr.skipBitmap(dr.base + len(dr.bits)*8)
+			}
 		}
 		n++
 	}
+
+	// Check skip tables against the posting lists they describe.
+	if ix.numSkip > 0 {
+		dir := ix.slice(ix.skipDir, ix.numSkip*skipDirEntSize)
+		last := -1
+		for i := range ix.numSkip {
+			t := binary.BigEndian.Uint64(dir[i*skipDirEntSize:])
+			if int(t) <= last || t >= 1<<24 {
+				ix.corrupt()
+			}
+			last = int(t)
+			count, offset, enc := ix.findListEnc(uint32(t))
+			skips := ix.findSkips(uint32(t), count)
+			if skips == nil || enc == encBitmap {
+				ix.corrupt()
+			}
+			data := ix.slice(ix.postData+offset+3, -1)
+			var dr deltaReader
+			dr.initList(ix, data, enc, count)
+			fileid := -1
+			for j := 1; j <= count; j++ {
+				fileid += dr.next()
+				if j%ix.skipInterval == 0 {
+					id, bit := ix.skipEntry(skips, j/ix.skipInterval)
+					if id != fileid || bit != (len(data)-len(dr.d))*8-int(dr.nb) {
+						ix.corrupt()
+					}
+				}
+			}
+		}
+	}
 	return nil
 }
@@ -10,10 +10,20 @@ import (
 )
 
 type deltaReader struct {
-	ix *Index
-	d  []byte
-	b  uint64
-	nb uint
+	ix  *Index
+	d   []byte
+	b   uint64
+	nb  uint
+	enc int // list encoding; see postenc.go
+
+	// for encBitmap and encBlock lists
+	n     int    // deltas left to read (encBitmap) or decode (encBlock)
+	bits  []byte // bitmap
+	base  int    // file ID of bit 0
+	pos   int    // next bit to examine
+	prev  int    // previous bit set
+	block []int  // decoded deltas left in current block
+	buf   [blockLen]int
 }
 
 func (r *deltaReader) init(ix *Index, data []byte) {
@@ -21,6 +31,7 @@ func (r *deltaReader) init(ix *Index, data []byte) {
 	r.d = data
 	r.b = 0
 	r.nb = 0
+	r.enc = encGamma
 }
 
 func (r *deltaReader) clearBits() {
@@ -28,10 +39,28 @@ func (r *deltaReader) clearBits() {
 	r.nb = 0
 }
 
+// seekBit positions r at the given bit offset in data,
+// which must be γ-coded (version 2 or later).
+func (r *deltaReader) seekBit(data []byte, bit int) {
+	r.d = data[bit/8:]
+	r.clearBits()
+	if n := uint(bit % 8); n > 0 {
+		r.b = uint64(r.d[0]) >> n
+		r.nb = 8 - n
+		r.d = r.d[1:]
+	}
+}
+
 const deltaZeroEnc = 16
 
 func (r *deltaReader) next() int {
-	if r.ix.version == 2 {
+	switch r.enc {
+	case encBitmap:
+		return r.nextBitmap()
+	case encBlock:
+		return r.nextBlock()
+	}
+	if r.ix.version >= 2 {
 		i := r.next64()
 		if i == deltaZeroEnc {
 			i = 0
@@ -96,7 +125,7 @@ func (w *deltaWriter) init(out *Buffer) {
 }
 
 func (w *deltaWriter) Write(x int) {
-	if writeVersion == 2 {
+	if writeVersion >= 2 {
 		if x == 0 {
 			x = deltaZeroEnc
 		} else if x >= deltaZeroEnc {
@@ -0,0 +1,96 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"slices"
+
+	"github.com/google/codesearch/transcode"
+)
+
+// Text encodings of indexed files.
+// See read.go for details of the on-disk format.
+//
+// The IndexWriter decodes files in other encodings to UTF-8 before
+// indexing them, so their posting lists describe the decoded text.
+// Searchers decode them the same way using the transcode package.
+
+const (
+	sectionEncoding = "encoding"
+
+	encodingEntrySize = 8 + 8
+)
+
+// A fileEncoding records the encoding of a file not encoded in UTF-8.
+type fileEncoding struct {
+	fileid int
+	enc    transcode.Encoding
+}
+
+// initEncodings reads the list of file encodings in sec.
+func (ix *Index) initEncodings(sec section) {
+	if sec.size%encodingEntrySize != 0 {
+		ix.corrupt()
+	}
+	ix.encodings = make([]fileEncoding, sec.size/encodingEntrySize)
+	last := -1
+	for i := range ix.encodings {
+		off := sec.offset + i*encodingEntrySize
+		id, enc := ix.uint64(off), ix.uint64(off+8)
+		if id <= last || id >= ix.numName || enc == int(transcode.UTF8) {
+			ix.corrupt()
+		}
+		ix.encodings[i] = fileEncoding{id, transcode.Encoding(enc)}
+		last = id
+	}
+}
+
+// encoding returns the encoding of the file with the given fileid.
+func (ix *Index) encoding(fileid int) transcode.Encoding {
+	i, ok := slices.BinarySearchFunc(ix.encodings, fileid, cmpEncoding)
+	if !ok {
+		return transcode.UTF8
+	}
+	return ix.encodings[i].enc
+}
+
+func cmpEncoding(e fileEncoding, fileid int) int {
+	return e.fileid - fileid
+}
+
+// writeEncodings writes the list of file encodings to out,
+// reporting false if the list is empty.
+func writeEncodings(out *Buffer, encs []fileEncoding) (section, bool) {
+	if len(encs) == 0 {
+		return section{}, false
+	}
+	start := out.Offset()
+	for _, e := range encs {
+		out.WriteUint(e.fileid)
+		out.WriteUint(int(e.enc))
+	}
+	return section{sectionEncoding, start, out.Offset() - start}, true
+}
+
+// mergeEncodings returns the file encodings
+// in an index merging the given sources.
+func mergeEncodings(srcs []mergeSource) []fileEncoding {
+	var encs []fileEncoding
+	for _, src := range srcs {
+		for _, r := range src.idmap {
+			lo, _ := slices.BinarySearchFunc(src.ix.encodings, r.lo, cmpEncoding)
+			for _, e := range src.ix.encodings[lo:] {
+				if e.fileid >= r.hi {
+					break
+				}
+				encs = append(encs, fileEncoding{r.new + e.fileid - r.lo, e.enc})
+			}
+		}
+	}
+	slices.SortFunc(encs, func(x, y fileEncoding) int {
+		return x.fileid - y.fileid
+	})
+	return encs
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"crypto/sha256"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/codesearch/transcode"
+)
+
+var encodingFiles = map[string]string{
+	"/e/latin1.c":  "/* caf\xe9 */\nint x;\n",
+	"/e/plain.c":   "int y;\n",
+	"/e/utf16.rc":  "\xff\xfeI\x00D\x00_\x00M\x00E\x00N\x00U\x00 \x00\xe9\x00\n\x00",
+	"/e/utf16be.h": "\x00#\x00d\x00e\x00f\x00i\x00n\x00e\x00 \x00Z\x00Z\x00Z\x00\n",
+}
+
+func TestEncoding(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "index")
+	buildIndex(out, []string{"/e"}, encodingFiles)
+	ix := Open(out)
+	if err := ix.Check(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, ix, "/e/latin1.c", "/e/plain.c", "/e/utf16.rc", "/e/utf16be.h")
+
+	// The posting lists describe the decoded text.
+	checkPosting(t, ix, "afé", 0)
+	checkPosting(t, ix, "ENU", 2)
+	checkPosting(t, ix, "U é", 2)
+	checkPosting(t, ix, "def", 3)
+	checkPosting(t, ix, "int", 0, 1)
+
+	// The meta records the encoding, and the size and hash
+	// of the file as stored, not as decoded.
+	want := []transcode.Encoding{transcode.Latin1, transcode.UTF8, transcode.UTF16LE, transcode.UTF16BE}
+	for id, name := range []string{"/e/latin1.c", "/e/plain.c", "/e/utf16.rc", "/e/utf16be.h"} {
+		m, _ := ix.Meta(id)
+		data := encodingFiles[name]
+		if m.Encoding != want[id] || m.Size != int64(len(data)) || m.Hash != sha256.Sum256([]byte(data)) {
+			t.Errorf("Meta(%s) = %v, %d bytes, want %v, %d bytes", name, m.Encoding, m.Size, want[id], len(data))
+		}
+	}
+
+	// Merging keeps track of the encodings.
+	out2 := filepath.Join(dir, "index2")
+	buildIndex(out2, []string{"/a", "/f"}, map[string]string{"/a/x": "hello", "/f/y": "caf\xe9"})
+	out3 := filepath.Join(dir, "index3")
+	Merge(out3, out2, out)
+	ix3 := Open(out3)
+	checkFiles(t, ix3, "/a/x", "/e/latin1.c", "/e/plain.c", "/e/utf16.rc", "/e/utf16be.h", "/f/y")
+	var have []transcode.Encoding
+	for id := range ix3.numName {
+		m, _ := ix3.Meta(id)
+		have = append(have, m.Encoding)
+	}
+	want = append(append([]transcode.Encoding{transcode.UTF8}, want...), transcode.Latin1)
+	if !slices.Equal(have, want) {
+		t.Errorf("merged encodings = %v, want %v", have, want)
+	}
+
+	// A file that turns out to be Latin-1 only after the detection
+	// window is decoded as Latin-1 all the same.
+	late := strings.Repeat("int x;\n", 1000) + "/* caf\xe9 */\n"
+	out4 := filepath.Join(dir, "index4")
+	buildIndex(out4, []string{"/l"}, map[string]string{"/l/late.c": late})
+	ix4 := Open(out4)
+	checkFiles(t, ix4, "/l/late.c")
+	checkPosting(t, ix4, "afé", 0)
+	if m, _ := ix4.Meta(0); m.Encoding != transcode.Latin1 {
+		t.Errorf("Meta(late.c).Encoding = %v, want %v", m.Encoding, transcode.Latin1)
+	}
+}
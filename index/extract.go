@@ -0,0 +1,372 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Archives.
+//
+// When IndexWriter.Zip is set, the IndexWriter indexes the files in
+// archives instead of the archives themselves. A file in an archive
+// is named "archive\x01member", where archive is the name of the
+// archive file and member is the name of the file in the archive.
+// The members are added in sorted order, so the index does not depend
+// on the order in which the archive stores them.
+//
+// An Extractor reads the archives of one format. The extractors are
+// registered by file name extension, and the IndexWriter and Opener
+// both use the registered extractors, so a program that registers an
+// extractor for another format can both index and search its archives.
+// See archive.go for the built-in extractors.
+
+// An Extractor reads archives of a particular format.
+type Extractor interface {
+	// Open opens the archive read from f, which is the file
+	// with the given name. If f cannot be read as an archive
+	// but should be indexed as an ordinary file, Open returns
+	// ErrNotArchive.
+	Open(name string, f io.Reader) (Archive, error)
+}
+
+// An ExtractorFunc is an Extractor implemented by a function.
+type ExtractorFunc func(name string, f io.Reader) (Archive, error)
+
+func (fn ExtractorFunc) Open(name string, f io.Reader) (Archive, error) {
+	return fn(name, f)
+}
+
+// An Archive is an open archive.
+type Archive interface {
+	// Members returns the files in the archive, in any order.
+	// The names must be distinct.
+	Members() []Member
+
+	// Open opens the member with the given name.
+	// It may be called any number of times, for any member,
+	// until the archive is closed.
+	Open(name string) (io.ReadCloser, error)
+
+	// Close releases the resources held by the archive.
+	Close() error
+}
+
+// A Member describes a file in an archive.
+type Member struct {
+	Name    string
+	ModTime time.Time // modification time; zero if unknown
+}
+
+// ErrNotArchive is returned by an Extractor's Open method
+// to indicate that a file should be indexed as an ordinary file.
+var ErrNotArchive = errors.New("not an archive")
+
+var extractors struct {
+	sync.RWMutex
+	byName map[string]Extractor
+	byExt  map[string]Extractor
+}
+
+// RegisterExtractor registers e under the given name, as the extractor
+// for the archives whose names end in any of the given extensions,
+// such as ".zip". If several extensions match a file name, the longest
+// one wins, so that, for example, ".tar.gz" takes precedence over ".gz".
+// RegisterExtractor panics if the name or an extension is already
+// registered.
+func RegisterExtractor(name string, e Extractor, exts ...string) {
+	extractors.Lock()
+	defer extractors.Unlock()
+	if extractors.byName == nil {
+		extractors.byName = make(map[string]Extractor)
+		extractors.byExt = make(map[string]Extractor)
+	}
+	if _, ok := extractors.byName[name]; ok {
+		panic("index: RegisterExtractor called twice for " + name)
+	}
+	extractors.byName[name] = e
+	for _, ext := range exts {
+		if _, ok := extractors.byExt[ext]; ok {
+			panic("index: RegisterExtractor called twice for " + ext)
+		}
+		extractors.byExt[ext] = e
+	}
+}
+
+// LookupExtractor returns the extractor registered under the given name,
+// or nil if there is none.
+func LookupExtractor(name string) Extractor {
+	extractors.RLock()
+	defer extractors.RUnlock()
+	return extractors.byName[name]
+}
+
+// ExtractorFor returns the extractor for the file with the given name,
+// or nil if the name does not end in a registered extension.
+func ExtractorFor(file string) Extractor {
+	extractors.RLock()
+	defer extractors.RUnlock()
+	var e Extractor
+	n := 0
+	for ext, x := range extractors.byExt {
+		if len(ext) > n && strings.HasSuffix(file, ext) {
+			e, n = x, len(ext)
+		}
+	}
+	return e
+}
+
+// isArchive reports whether ix indexes the file with the given name
+// as an archive.
+func (ix *IndexWriter) isArchive(name string) bool {
+	return ix.Zip && ExtractorFor(name) != nil
+}
+
+// addArchive adds the members of the archive read from f to the index.
+// It reports false if f should be added as an ordinary file instead.
+func (ix *IndexWriter) addArchive(name string, f io.Reader) (bool, error) {
+	a, err := ExtractorFor(name).Open(name, f)
+	if err == ErrNotArchive {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+	members := slices.Clone(a.Members())
+	slices.SortFunc(members, func(x, y Member) int {
+		return compareMemberNames(x.Name, y.Name)
+	})
+	members = slices.CompactFunc(members, func(x, y Member) bool {
+		return x.Name == y.Name
+	})
+	first := ix.numName
+	for _, m := range members {
+		r, err := a.Open(m.Name)
+		if err != nil {
+			log.Printf("%s: %s: %v", name, m.Name, err)
+			continue
+		}
+		err = ix.add(name+"\x01"+m.Name, r, m.ModTime)
+		r.Close()
+		if err != nil {
+			log.Printf("%s: %s: %v", name, m.Name, err)
+		}
+	}
+	if mtime := modTime(f); ix.numName > first && !mtime.IsZero() {
+		ix.archives = append(ix.archives, archiveMeta{first, fileSize(f, 0), mtime})
+	}
+	return true, nil
+}
+
+// Archive metadata.
+// See read.go for details of the on-disk format.
+//
+// The file metadata for the members of an archive describes the
+// members, so to reuse them when the archive is unchanged, the index
+// also records the size and modification time of each archive,
+// keyed by the file ID of its first indexed member.
+
+const (
+	sectionArchives = "archives"
+
+	archiveEntrySize = 8 + 8 + 8
+)
+
+// An archiveMeta records the size and modification time of an archive
+// whose members start at fileid.
+type archiveMeta struct {
+	fileid  int
+	size    int64
+	modTime time.Time
+}
+
+func cmpArchive(a archiveMeta, fileid int) int {
+	return a.fileid - fileid
+}
+
+// initArchives reads the list of archives in sec.
+func (ix *Index) initArchives(sec section) {
+	if sec.size%archiveEntrySize != 0 {
+		ix.corrupt()
+	}
+	ix.archives = make([]archiveMeta, sec.size/archiveEntrySize)
+	last := -1
+	for i := range ix.archives {
+		off := sec.offset + i*archiveEntrySize
+		id := ix.uint64(off)
+		if id <= last || id >= ix.numName {
+			ix.corrupt()
+		}
+		ix.archives[i] = archiveMeta{id, int64(ix.uint64(off + 8)), time.Unix(0, int64(ix.uint64(off+16)))}
+		last = id
+	}
+}
+
+// archive returns the metadata of the archive whose first member
+// has the given fileid, reporting false if there is none.
+func (ix *Index) archive(fileid int) (archiveMeta, bool) {
+	i, ok := slices.BinarySearchFunc(ix.archives, fileid, cmpArchive)
+	if !ok {
+		return archiveMeta{}, false
+	}
+	return ix.archives[i], true
+}
+
+// writeArchives writes the list of archives to out,
+// reporting false if the list is empty.
+func writeArchives(out *Buffer, archives []archiveMeta) (section, bool) {
+	if len(archives) == 0 {
+		return section{}, false
+	}
+	start := out.Offset()
+	for _, a := range archives {
+		out.WriteUint(a.fileid)
+		out.WriteUint(int(a.size))
+		out.WriteUint(int(a.modTime.UnixNano()))
+	}
+	return section{sectionArchives, start, out.Offset() - start}, true
+}
+
+// mergeArchives returns the archives in an index merging the given
+// sources. The members of an archive are under the same root,
+// so the merge keeps or drops them together.
+func mergeArchives(srcs []mergeSource) []archiveMeta {
+	var archives []archiveMeta
+	for _, src := range srcs {
+		for _, r := range src.idmap {
+			lo, _ := slices.BinarySearchFunc(src.ix.archives, r.lo, cmpArchive)
+			for _, a := range src.ix.archives[lo:] {
+				if a.fileid >= r.hi {
+					break
+				}
+				a.fileid = r.new + a.fileid - r.lo
+				archives = append(archives, a)
+			}
+		}
+	}
+	slices.SortFunc(archives, func(x, y archiveMeta) int {
+		return x.fileid - y.fileid
+	})
+	return archives
+}
+
+// compareMemberNames compares two member names,
+// treating / as less than any other byte,
+// so that the members of a directory sort together.
+func compareMemberNames(x, y string) int {
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] == y[i] {
+			continue
+		}
+		if x[i] == '/' {
+			return -1
+		}
+		if y[i] == '/' {
+			return +1
+		}
+		return cmp.Compare(x[i], y[i])
+	}
+	return cmp.Compare(len(x), len(y))
+}
+
+// An Opener opens indexed files by name, including files in archives,
+// which are named "archive\x01member", and files in git repositories,
+// which are named "repo@rev/path" (see AddGit). It keeps the most
+// recently used archive open, so opening the members of an archive one
+// after another, as when searching the files in index order, reads the
+// archive once. The zero Opener is ready to use.
+type Opener struct {
+	name string    // name of open archive
+	file io.Closer // open archive file
+	arch Archive   // open archive, or nil if it could not be opened
+	err  error     // error opening archive
+
+	git map[string]*gitBatch // readers for git repositories, by directory
+}
+
+// Open opens the indexed file with the given name for reading.
+func (o *Opener) Open(name string) (io.ReadCloser, error) {
+	file, elem, ok := strings.Cut(name, "\x01")
+	if !ok {
+		return o.openFile(name)
+	}
+	if file != o.name {
+		o.closeArchive()
+		o.name = file
+		o.arch, o.err = o.openArchive(file)
+	}
+	if o.err != nil {
+		return nil, o.err
+	}
+	return o.arch.Open(elem)
+}
+
+// openFile opens a file that is not in an archive.
+func (o *Opener) openFile(name string) (io.ReadCloser, error) {
+	f, err := os.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if os.IsNotExist(err) {
+		if r, ok, err := o.openGit(name); ok {
+			return r, err
+		}
+	}
+	return nil, err
+}
+
+func (o *Opener) openArchive(file string) (Archive, error) {
+	e := ExtractorFor(file)
+	if e == nil {
+		return nil, fmt.Errorf("%s: %w", file, ErrNotArchive)
+	}
+	f, err := o.openFile(file)
+	if err != nil {
+		return nil, err
+	}
+	a, err := e.Open(file, f)
+	if err == ErrNotArchive {
+		err = fmt.Errorf("%s: %w", file, ErrNotArchive)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	o.file = f
+	return a, nil
+}
+
+// closeArchive closes the archive the Opener has open, if any.
+func (o *Opener) closeArchive() error {
+	var err error
+	if o.arch != nil {
+		err = o.arch.Close()
+	}
+	if o.file != nil {
+		err = errors.Join(err, o.file.Close())
+	}
+	o.name, o.file, o.arch, o.err = "", nil, nil, nil
+	return err
+}
+
+// Close closes the files and repositories the Opener has open.
+func (o *Opener) Close() error {
+	err := o.closeArchive()
+	for _, g := range o.git {
+		err = errors.Join(err, g.close())
+	}
+	o.git = nil
+	return err
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openLines opens a test archive format in which
+// each line "name: text" is a member holding text.
+func openLines(name string, f io.Reader) (Archive, error) {
+	a := new(memberList)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		name, text, ok := strings.Cut(s.Text(), ": ")
+		if !ok {
+			return nil, ErrNotArchive
+		}
+		a.add(Member{Name: name}, func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(text)), nil
+		})
+	}
+	return a, s.Err()
+}
+
+func init() {
+	RegisterExtractor("lines", ExtractorFunc(openLines), ".lines")
+}
+
+func TestExtractor(t *testing.T) {
+	for _, tt := range []struct {
+		file, want string
+	}{
+		{"x.zip", "zip"},
+		{"x.tar.gz", "tgz"},
+		{"x.c.gz", "gzip"},
+		{"x.lines", "lines"},
+		{"x.c", ""},
+	} {
+		e := ExtractorFor(tt.file)
+		if tt.want == "" {
+			if e != nil {
+				t.Errorf("ExtractorFor(%q) = %v, want nil", tt.file, e)
+			}
+			continue
+		}
+		if e == nil {
+			t.Errorf("ExtractorFor(%q) = nil, want %s", tt.file, tt.want)
+			continue
+		}
+		// Compare the functions by the archives they open.
+		if _, err := e.Open(tt.file, strings.NewReader("not: an archive\n")); (err == nil) != (tt.want == "lines") {
+			t.Errorf("ExtractorFor(%q) is not the %s extractor", tt.file, tt.want)
+		}
+	}
+	if LookupExtractor("lines") == nil || LookupExtractor("nope") != nil {
+		t.Errorf("LookupExtractor does not find the registered extractors")
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.lines":   "z: last\nm/n: potatoes\nm: middle potatoes\n",
+		"b.lines":   "this is not in the format\n",
+		"plain.txt": "plain potatoes",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := filepath.Join(t.TempDir(), "index")
+	ix := Create(out)
+	ix.Zip = true
+	ix.AddRoots([]Path{MakePath(dir)})
+	for _, name := range []string{"a.lines", "b.lines", "plain.txt"} {
+		if err := ix.AddFile(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix.Flush()
+
+	rx := Open(out)
+	a := filepath.Join(dir, "a.lines") + "\x01"
+	checkFiles(t, rx, a+"m", a+"m/n", a+"z", filepath.Join(dir, "b.lines"), filepath.Join(dir, "plain.txt"))
+	checkPosting(t, rx, "pot", 0, 1, 4)
+
+	var o Opener
+	defer o.Close()
+	for _, tt := range []struct{ name, want string }{
+		{a + "m", "middle potatoes"},
+		{a + "z", "last"},
+		{filepath.Join(dir, "plain.txt"), "plain potatoes"},
+	} {
+		r, err := o.Open(tt.name)
+		if err != nil {
+			t.Errorf("Open(%q): %v", tt.name, err)
+			continue
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		if string(data) != tt.want {
+			t.Errorf("Open(%q) read %q, want %q", tt.name, data, tt.want)
+		}
+	}
+}
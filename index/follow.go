@@ -0,0 +1,117 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Symbolic links.
+//
+// With RootConfig.Follow set, Build walks a root with walkFollow instead
+// of filepath.Walk, indexing the files and directories that symbolic
+// links refer to in place of the links, and keeping only the first path
+// it finds to each file. With RootConfig.Canonical also set, it then
+// renames the files it found with canonicalNames.
+
+// walkFollow walks the file tree rooted at root, calling fn for each
+// file or directory in the tree, like filepath.Walk, except that it
+// follows symbolic links, passing fn the information about the file
+// or directory a link refers to. To avoid loops and duplicates,
+// walkFollow walks each directory only once, at the first path by which
+// it reaches it, in lexical order. A directory that fn skips does not
+// count, so a link to it elsewhere is still walked. walkFollow passes
+// fn every path to a file, leaving it to fn to avoid adding a file
+// twice. It identifies directories by device and inode number where
+// the system has them; see fileKey.
+func walkFollow(root string, fn filepath.WalkFunc) error {
+	w := &follower{fn: fn, seen: make(map[any]bool)}
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = w.walk(root, info)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+type follower struct {
+	fn   filepath.WalkFunc
+	seen map[any]bool // directories already walked, by fileKey
+}
+
+func (w *follower) walk(path string, info os.FileInfo) error {
+	if !info.IsDir() {
+		return w.fn(path, info, nil)
+	}
+	key, ok := fileKey(path, info)
+	if ok && w.seen[key] {
+		return nil
+	}
+	if err := w.fn(path, info, nil); err != nil {
+		return err
+	}
+	if ok {
+		w.seen[key] = true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return w.fn(path, info, err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return w.fn(path, info, err)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		file := filepath.Join(path, name)
+		info, err := os.Stat(file)
+		if err != nil {
+			// A broken link, for example.
+			if linfo, lerr := os.Lstat(file); lerr == nil {
+				info = linfo
+			}
+			if err := w.fn(file, info, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := w.walk(file, info); err != nil {
+			if err == filepath.SkipDir && info.IsDir() {
+				continue
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// canonicalNames returns the canonical names of the given files under
+// root, which are their paths with symbolic links resolved, relative to
+// the root with links resolved. A file whose canonical path is outside
+// the root keeps the name it has.
+func canonicalNames(root string, names []string) []string {
+	real, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		real = root
+	}
+	canon := make([]string, 0, len(names))
+	for _, name := range names {
+		if r, err := filepath.EvalSymlinks(name); err == nil {
+			if rel, err := filepath.Rel(real, r); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				name = filepath.Join(root, rel)
+			}
+		}
+		canon = append(canon, name)
+	}
+	return canon
+}
@@ -0,0 +1,23 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// fileKey returns a key identifying the file at path, with the given info,
+// and reports whether it could determine one. Without device and inode
+// numbers, the key is the path with symbolic links resolved.
+func fileKey(path string, info os.FileInfo) (any, bool) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, false
+	}
+	return real, true
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func symlink(t *testing.T, old, new string) {
+	t.Helper()
+	if err := os.Symlink(old, new); err != nil {
+		t.Skipf("symlink: %v", err)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"root/real/f.c":    "int f;\n",
+		"root/.hidden/h.c": "int h;\n",
+		"root/skip/s.c":    "int s;\n",
+		"ext/e.c":          "int e;\n",
+	})
+	root := filepath.Join(dir, "root")
+	name := func(s string) string { return filepath.Join(root, filepath.FromSlash(s)) }
+	symlink(t, "real", name("a-link"))     // walked before real
+	symlink(t, "real", name("z-link"))     // a second link to real
+	symlink(t, "..", name("real/up"))      // a loop
+	symlink(t, "f.c", name("real/g.c"))    // a second link to real/f.c
+	symlink(t, ".hidden", name("visible")) // a skipped directory
+	symlink(t, "skip", name("unskipped"))
+	symlink(t, "../ext", name("ext"))
+
+	cfg := &RootConfig{
+		Follow: true,
+		Skip: func(path string, isDir bool) bool {
+			return isDir && filepath.Base(path) == "skip"
+		},
+	}
+	walk := func() []string {
+		t.Helper()
+		var names []string
+		err := walkRoot(context.Background(), root, cfg, func(err error) { t.Error(err) }, func(name string) {
+			names = append(names, name)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return names
+	}
+
+	// Each file and directory is found once, at its first name,
+	// and directories skipped at one name are found at another.
+	want := []string{name("a-link/f.c"), name("ext/e.c"), name("unskipped/s.c"), name("visible/h.c")}
+	if names := walk(); !slices.Equal(names, want) {
+		t.Errorf("walk = %q, want %q", names, want)
+	}
+
+	// Canonical names resolve the links within the root.
+	cfg.Canonical = true
+	want = []string{name(".hidden/h.c"), name("ext/e.c"), name("real/f.c"), name("skip/s.c")}
+	if names := walk(); !slices.Equal(names, want) {
+		t.Errorf("walk with canonical names = %q, want %q", names, want)
+	}
+
+	// Without following links, only the files under the root are found.
+	cfg.Follow = false
+	want = []string{name("real/f.c")}
+	if names := walk(); !slices.Equal(names, want) {
+		t.Errorf("walk without following links = %q, want %q", names, want)
+	}
+}
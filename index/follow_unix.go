@@ -0,0 +1,27 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package index
+
+import (
+	"os"
+	"syscall"
+)
+
+// A devIno identifies a file by device and inode number.
+type devIno struct {
+	dev, ino uint64
+}
+
+// fileKey returns a key identifying the file at path, with the given info,
+// and reports whether it could determine one.
+func fileKey(path string, info os.FileInfo) (any, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, false
+	}
+	return devIno{uint64(st.Dev), uint64(st.Ino)}, true
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// File systems.
+//
+// AddFS indexes the files in any fs.FS, such as an embed.FS, an
+// fstest.MapFS or the result of os.DirFS, naming each file by its path
+// in the file system joined to a prefix. It handles each file the way
+// AddFile does: it reuses unchanged files from an old index, reads
+// files concurrently when ix.Workers > 1, and with ix.Zip set, indexes
+// the content of archives. AddFile itself is the special case of a
+// file system holding the files of the host operating system.
+
+// AddFS adds the regular files in fsys to the index, under the names
+// formed by joining prefix and each file's path in fsys with
+// filepath.Join. AddFS does not record prefix as a root; the caller
+// adds it with AddRoots, as for AddFile. AddFS adds the files
+// in index order, no matter what order fsys lists them in, so the
+// index depends only on the files. If skip is non-nil, AddFS omits the
+// files and directories for which skip reports true, given the path in
+// fsys and the directory entry; omitting a directory omits everything
+// in it. AddFS logs errors reading individual files and directories
+// using package log, and returns an error only if it cannot read the
+// top directory of fsys.
+func (ix *IndexWriter) AddFS(fsys fs.FS, prefix string, skip func(path string, d fs.DirEntry) bool) error {
+	var paths []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == "." {
+				return err
+			}
+			log.Printf("%s: %v", filepath.Join(prefix, filepath.FromSlash(path)), err)
+			return nil
+		}
+		if path != "." && skip != nil && skip(path, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(paths, compareMemberNames)
+	for _, path := range paths {
+		name := filepath.Join(prefix, filepath.FromSlash(path))
+		if err := ix.addFile(fsys, path, name); err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// An osFS is the file system of the host operating system,
+// as used by AddFile. Unlike the file systems from os.DirFS,
+// it accepts any name os.Open does.
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
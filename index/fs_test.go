@@ -0,0 +1,80 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+var fsFiles = fstest.MapFS{
+	"a.c":         {Data: []byte("int apple;\n")},
+	"a/x":         {Data: []byte("apple pie\n")},
+	"a/y":         {Data: []byte("banana split\n")},
+	"b/.hidden":   {Data: []byte("apple core\n")},
+	"b/z":         {Data: []byte("cherry apple\n"), ModTime: time.Unix(1e9, 0)},
+	"bin/tool":    {Data: []byte("apple\x00\x01")},
+	"skip/me":     {Data: []byte("apple tart\n")},
+	"dir/link":    {Data: []byte("a.c"), Mode: fs.ModeSymlink},
+	"empty/":      {Mode: fs.ModeDir},
+	"zzz/nested/": {Mode: fs.ModeDir},
+}
+
+func TestAddFS(t *testing.T) {
+	skip := func(path string, d fs.DirEntry) bool {
+		return strings.HasPrefix(d.Name(), ".") || d.IsDir() && path == "skip"
+	}
+	prefix := filepath.FromSlash("/fs")
+	want := []string{"/fs/a/x", "/fs/a/y", "/fs/a.c", "/fs/b/z"}
+	for i := range want {
+		want[i] = filepath.FromSlash(want[i])
+	}
+	binary := filepath.FromSlash("/fs/bin/tool")
+
+	for _, workers := range []int{1, 4} {
+		out := filepath.Join(t.TempDir(), "index")
+		ix := Create(out)
+		ix.Workers = workers
+		var skipped []string
+		ix.Skipped = func(name string, reason SkipReason, size int64) {
+			skipped = append(skipped, name)
+		}
+		ix.AddRoots([]Path{MakePath(prefix)})
+		if err := ix.AddFS(fsFiles, prefix, skip); err != nil {
+			t.Fatal(err)
+		}
+		ix.Flush()
+
+		rx := Open(out)
+		if err := rx.Check(); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, rx, want...)
+		if rx.numName != len(want) {
+			t.Errorf("workers=%d: %d files, want %d", workers, rx.numName, len(want))
+		}
+		checkPosting(t, rx, "ppl", 0, 2, 3)
+		if m, _ := rx.Meta(3); !m.ModTime.Equal(time.Unix(1e9, 0)) {
+			t.Errorf("workers=%d: Meta(3).ModTime = %v, want %v", workers, m.ModTime, time.Unix(1e9, 0))
+		}
+		if len(skipped) != 1 || skipped[0] != binary {
+			t.Errorf("workers=%d: skipped %q, want [%q]", workers, skipped, binary)
+		}
+	}
+
+	// Only an error reading the top directory is returned.
+	ix := Create(filepath.Join(t.TempDir(), "index"))
+	if err := ix.AddFS(fstest.MapFS{}, prefix, nil); err != nil {
+		t.Errorf("AddFS(empty) = %v, want nil", err)
+	}
+	sub, _ := fs.Sub(fsFiles, "nope")
+	if err := ix.AddFS(sub, prefix, nil); err == nil {
+		t.Errorf("AddFS(missing) = nil, want error")
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Git repositories.
+//
+// AddGit indexes the files in a revision of a git repository without
+// checking it out, naming each file "repo@rev/path", where repo is
+// the repository directory, rev is the revision as given, and path is
+// the file's path in the revision's tree. An Opener opens such names
+// by asking git for the file in the same revision. Both run the git
+// command, reading the files with a single "git cat-file --batch".
+
+// AddGit adds the files in revision rev of the git repository in dir
+// to the index, under names of the form "dir@rev/path". AddGit does not
+// record "dir@rev" as a root; the caller adds it with AddRoots.
+// If skip is non-nil, AddGit omits the files for which skip reports
+// true, given the slash-separated path. If ctx is canceled, AddGit
+// stops and returns ctx.Err().
+func (ix *IndexWriter) AddGit(ctx context.Context, dir, rev string, skip func(path string) bool) error {
+	if rev == "" || strings.HasPrefix(rev, "-") || strings.ContainsAny(rev, "\n\x00") {
+		return fmt.Errorf("%s: invalid git revision %q", dir, rev)
+	}
+	out, err := gitCommand(dir, "ls-tree", "-r", "-z", "--full-tree", rev).Output()
+	if err != nil {
+		return gitError(dir, err)
+	}
+	type blob struct {
+		path string
+		hash string
+	}
+	var blobs []blob
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// mode SP type SP object TAB path
+		info, path, ok := strings.Cut(entry, "\t")
+		f := strings.Fields(info)
+		if !ok || len(f) != 3 || f[1] != "blob" || f[0] == "120000" {
+			continue // not a regular file
+		}
+		if skip != nil && skip(path) {
+			continue
+		}
+		blobs = append(blobs, blob{path, f[2]})
+	}
+	slices.SortFunc(blobs, func(x, y blob) int {
+		return compareMemberNames(x.path, y.path)
+	})
+
+	g, err := startGitBatch(dir)
+	if err != nil {
+		return err
+	}
+	defer g.close()
+	for _, b := range blobs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := dir + "@" + rev + "/" + b.path
+		r, err := g.open(b.hash)
+		if err != nil {
+			return err
+		}
+		if err := ix.Add(name, r); err != nil {
+			log.Printf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func gitCommand(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return cmd
+}
+
+// gitError returns an error for a failed git command run in dir,
+// including the command's error output.
+func gitError(dir string, err error) error {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return fmt.Errorf("%s: git: %s", dir, bytes.TrimSpace(ee.Stderr))
+	}
+	return fmt.Errorf("%s: git: %v", dir, err)
+}
+
+// SplitGitRoot splits a root of the form "repo@rev", as added by AddGit,
+// into the repository directory and the revision. Both may contain '@'
+// (as in "HEAD@{1}"), so the split is at the first '@' that follows
+// the name of a git repository. SplitGitRoot reports false if there
+// is no such '@' or the revision is empty.
+func SplitGitRoot(root string) (dir, rev string, ok bool) {
+	for i := 1; i < len(root); i++ {
+		if root[i] == '@' && isGitRepo(root[:i]) {
+			if i+1 == len(root) {
+				break
+			}
+			return root[:i], root[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+// isGitRepo reports whether dir is a git repository.
+func isGitRepo(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return true
+	}
+	// A bare repository.
+	_, err1 := os.Stat(filepath.Join(dir, "HEAD"))
+	_, err2 := os.Stat(filepath.Join(dir, "objects"))
+	return err1 == nil && err2 == nil
+}
+
+// A gitBatch reads objects from a git repository
+// using a running "git cat-file --batch".
+type gitBatch struct {
+	dir  string
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+	out  *bufio.Reader
+	rest int64 // bytes left in the current object, including the final newline
+}
+
+func startGitBatch(dir string) (*gitBatch, error) {
+	g := &gitBatch{dir: dir, cmd: gitCommand(dir, "cat-file", "--batch")}
+	in, err := g.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := g.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := g.cmd.Start(); err != nil {
+		return nil, gitError(dir, err)
+	}
+	g.in = in
+	g.out = bufio.NewReader(out)
+	return g, nil
+}
+
+// open returns a reader for the blob with the given object name,
+// such as a hash or "rev:path". The reader is valid until the next
+// call to open.
+func (g *gitBatch) open(object string) (io.Reader, error) {
+	if strings.Contains(object, "\n") {
+		// The batch protocol is line-oriented.
+		return nil, fmt.Errorf("%s: invalid git object name %q", g.dir, object)
+	}
+	if g.rest > 0 {
+		if _, err := g.out.Discard(int(g.rest)); err != nil {
+			return nil, gitError(g.dir, err)
+		}
+		g.rest = 0
+	}
+	if _, err := fmt.Fprintf(g.in, "%s\n", object); err != nil {
+		return nil, gitError(g.dir, err)
+	}
+	line, err := g.out.ReadString('\n')
+	if err != nil {
+		return nil, gitError(g.dir, err)
+	}
+	// object SP type SP size LF, or object SP missing LF.
+	if strings.HasSuffix(line, " missing\n") || strings.HasSuffix(line, " ambiguous\n") {
+		return nil, &os.PathError{Op: "open", Path: g.dir + "@" + object, Err: os.ErrNotExist}
+	}
+	f := strings.Fields(line)
+	if len(f) != 3 {
+		return nil, fmt.Errorf("%s: git: unexpected output %q", g.dir, line)
+	}
+	size, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: git: unexpected output %q", g.dir, line)
+	}
+	g.rest = size + 1
+	if f[1] != "blob" {
+		return nil, fmt.Errorf("%s: %s is a %s, not a file", g.dir, object, f[1])
+	}
+	return &gitBlobReader{g, size}, nil
+}
+
+func (g *gitBatch) close() error {
+	g.in.Close()
+	return g.cmd.Wait()
+}
+
+// A gitBlobReader reads the content of a blob from a gitBatch.
+type gitBlobReader struct {
+	g *gitBatch
+	n int64 // bytes left in the blob
+}
+
+func (r *gitBlobReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	n, err := r.g.out.Read(p)
+	r.n -= int64(n)
+	r.g.rest -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// openGit opens the file with the given name, of the form
+// "repo@rev/path", by reading it from the git repository.
+// It reports false if the name is not of that form.
+func (o *Opener) openGit(name string) (io.ReadCloser, bool, error) {
+	dir, rest, ok := SplitGitRoot(name)
+	if !ok {
+		return nil, false, nil
+	}
+	if strings.Contains(name, "\n") {
+		return nil, true, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	g, err := o.gitBatch(dir)
+	if err != nil {
+		return nil, true, err
+	}
+	// The revision may itself contain slashes,
+	// so try each slash as the end of the revision.
+	for j := 0; j < len(rest); j++ {
+		if rest[j] != '/' {
+			continue
+		}
+		r, err := g.open(rest[:j] + ":" + rest[j+1:])
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, true, err
+		}
+		return io.NopCloser(bytes.NewReader(data)), true, nil
+	}
+	return nil, true, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// gitBatch returns a gitBatch for the repository in dir,
+// starting one if needed.
+func (o *Opener) gitBatch(dir string) (*gitBatch, error) {
+	if g := o.git[dir]; g != nil {
+		return g, nil
+	}
+	g, err := startGitBatch(dir)
+	if err != nil {
+		return nil, err
+	}
+	if o.git == nil {
+		o.git = make(map[string]*gitBatch)
+	}
+	o.git[dir] = g
+	return g, nil
+}
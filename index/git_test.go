@@ -0,0 +1,157 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// makeGitRepo creates a git repository in a temporary directory,
+// committing each map of files in turn, and returns the directory.
+func makeGitRepo(t *testing.T, commits ...map[string]string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1",
+			"GIT_AUTHOR_NAME=gopher", "GIT_AUTHOR_EMAIL=gopher@example.com",
+			"GIT_COMMITTER_NAME=gopher", "GIT_COMMITTER_EMAIL=gopher@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	for _, files := range commits {
+		for name, data := range files {
+			file := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(file, []byte(data), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+		git("add", ".")
+		git("commit", "-q", "-m", "commit")
+	}
+	git("branch", "release/v1", "HEAD~1")
+	return dir
+}
+
+func TestGit(t *testing.T) {
+	dir := makeGitRepo(t,
+		map[string]string{
+			"a/x":       "hello world",
+			"a.go":      "package a // version one",
+			"b/c/d":     "deep potatoes",
+			".hidden":   "secret potatoes",
+			"ab/binary": "a\x00b",
+		},
+		map[string]string{
+			"a.go": "package a // version two",
+			"new":  "new potatoes",
+		},
+	)
+
+	out := filepath.Join(t.TempDir(), "index")
+	ix := Create(out)
+	main, v1 := dir+"@main", dir+"@release/v1"
+	ix.AddRoots([]Path{MakePath(main), MakePath(v1)})
+	skip := func(path string) bool { return strings.HasPrefix(path, ".") }
+	if err := ix.AddGit(context.Background(), dir, "main", skip); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.AddGit(context.Background(), dir, "release/v1", skip); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.AddGit(context.Background(), dir, "nonexistent", nil); err == nil {
+		t.Errorf("AddGit(nonexistent) succeeded")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := ix.AddGit(ctx, dir, "main", skip); err != context.Canceled {
+		t.Errorf("AddGit with canceled context = %v, want %v", err, context.Canceled)
+	}
+	ix.Flush()
+
+	rx := Open(out)
+	if err := rx.Check(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, rx,
+		main+"/a/x", main+"/a.go", main+"/b/c/d", main+"/new",
+		v1+"/a/x", v1+"/a.go", v1+"/b/c/d",
+	)
+	checkPosting(t, rx, "two", 1)
+	checkPosting(t, rx, "one", 5)
+	checkPosting(t, rx, "pot", 2, 3, 6)
+
+	var o Opener
+	defer o.Close()
+	for _, tt := range []struct{ name, want string }{
+		{main + "/a.go", "package a // version two"},
+		{v1 + "/a.go", "package a // version one"},
+		{main + "/b/c/d", "deep potatoes"},
+		{main + "/new", "new potatoes"},
+	} {
+		r, err := o.Open(tt.name)
+		if err != nil {
+			t.Errorf("Open(%q): %v", tt.name, err)
+			continue
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || string(data) != tt.want {
+			t.Errorf("Open(%q) read %q, %v, want %q", tt.name, data, err, tt.want)
+		}
+	}
+	if _, err := o.Open(v1 + "/new"); !os.IsNotExist(err) {
+		t.Errorf("Open(%q) error = %v, want not exist", v1+"/new", err)
+	}
+
+	// A name with a newline is not sent to git,
+	// which would read it as two requests.
+	if _, err := o.Open(main + "/a.go\nmain:new"); !os.IsNotExist(err) {
+		t.Errorf("Open(name with newline) error = %v, want not exist", err)
+	}
+
+	// Revisions may contain '@'.
+	for _, rev := range []string{"main@{0}", "HEAD@{1}", "main~1"} {
+		root := dir + "@" + rev
+		d, r, ok := SplitGitRoot(root)
+		if !ok || d != dir || r != rev {
+			t.Errorf("SplitGitRoot(%q) = %q, %q, %v, want %q, %q, true", root, d, r, ok, dir, rev)
+		}
+		want := "package a // version one"
+		if rev == "main@{0}" {
+			want = "package a // version two"
+		}
+		r2, err := o.Open(root + "/a.go")
+		if err != nil {
+			t.Errorf("Open(%q): %v", root+"/a.go", err)
+			continue
+		}
+		data, _ := io.ReadAll(r2)
+		if string(data) != want {
+			t.Errorf("Open(%q) read %q, want %q", root+"/a.go", data, want)
+		}
+	}
+	for _, root := range []string{dir, dir + "@", filepath.Join(dir, "a") + "@main", "/nonexistent@main"} {
+		if d, r, ok := SplitGitRoot(root); ok {
+			t.Errorf("SplitGitRoot(%q) = %q, %q, true, want false", root, d, r)
+		}
+	}
+}
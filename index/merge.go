@@ -29,11 +29,15 @@ package index
 //
 // Copy the name index and posting list index into C's index and write the trailer.
 // Rename C's index onto the new index.
+//
+// Removing roots from an index A is the same process with only one input:
+// the fileid map for A discards the ranges under the removed roots.
 
 import (
-	"encoding/binary"
 	"fmt"
+	"log"
 	"os"
+	"slices"
 )
 
 // An idrange records that the half-open interval [lo, hi) maps to [new, new+hi-lo).
@@ -43,15 +47,24 @@ type idrange struct {
 
 // writeVersion is the index version that IndexWriter and Merge should write.
 // We only write older versions during testing.
-var writeVersion = 2
+var writeVersion = 4
 
 // Merge creates a new index in the file dst that corresponds to merging
 // the two indices src1 and src2.  If both src1 and src2 claim responsibility
 // for a path, src2 is assumed to be newer and is given preference.
 func Merge(dst, src1, src2 string) {
 	ix1 := Open(src1)
+	defer ix1.close()
 	ix2 := Open(src2)
+	defer ix2.close()
+	if err := merge(dst, ix1, ix2); err != nil {
+		log.Fatal(err)
+	}
+}
 
+// merge is like Merge but takes the open indexes
+// and returns an error writing the merged index.
+func merge(dst string, ix1, ix2 *Index) error {
 	// Build fileid maps.
 	var i1, i2, new int
 	var map1, map2 []idrange
@@ -115,20 +128,10 @@ func Merge(dst, src1, src2 string) {
 	if i2 < ix2.numName {
 		panic("merge: inconsistent index")
 	}
-	numName := new
-
-	ix := bufCreate(dst)
-	if writeVersion == 1 {
-		ix.WriteString(magicV1)
-	} else {
-		ix.WriteString(magicV2)
-	}
 
 	// Merged list of paths.
-	pathData := ix.Offset()
+	var roots []Path
 	last := MakePath("\xFF") // not a prefix of anything
-	writeVersion = 2
-	paths := NewPathWriter(ix, nil, writeVersion, 0)
 	p1 := ix1.Roots()
 	p2 := ix2.Roots()
 	for p1.Valid() || p2.Valid() {
@@ -144,110 +147,189 @@ func Merge(dst, src1, src2 string) {
 			continue
 		}
 		last = p
-		paths.Write(p)
+		roots = append(roots, p)
 	}
-	if writeVersion == 1 {
-		paths.Write(MakePath(""))
+
+	return writeMerged(dst, roots, []mergeSource{{ix1, map1}, {ix2, map2}})
+}
+
+// Remove creates a new index in the file dst that corresponds to
+// the index src without the given roots and the files they contain.
+// Each root to remove must be an indexed root or contain one.
+func Remove(dst, src string, remove []Path) error {
+	ix := Open(src)
+	defer ix.close()
+	remove = slices.Clone(remove)
+	slices.SortFunc(remove, Path.Compare)
+
+	all := slices.Collect(ix.Roots().All())
+	for _, r := range remove {
+		if !slices.ContainsFunc(all, func(root Path) bool { return root.HasPathPrefix(r) }) {
+			return fmt.Errorf("%s: not an indexed root", r)
+		}
+	}
+	var roots []Path
+	for _, root := range all {
+		if !slices.ContainsFunc(remove, root.HasPathPrefix) {
+			roots = append(roots, root)
+		}
+	}
+
+	// Build fileid map, skipping the ranges under the removed roots.
+	var i, new int
+	var idmap []idrange
+	names := ix.NamesAt(0, ix.numName)
+	name := names.Path()
+	for _, root := range remove {
+		old := i
+		for i < ix.numName && name.Compare(root) < 0 {
+			names.Next()
+			name = names.Path()
+			i++
+		}
+		if old < i {
+			idmap = append(idmap, idrange{old, i, new})
+			new += i - old
+		}
+
+		// See Merge for the choice of limit.
+		limit := MakePath(root.String() + "\x02")
+		for i < ix.numName && name.Compare(limit) < 0 {
+			names.Next()
+			name = names.Path()
+			i++
+		}
+	}
+	if i < ix.numName {
+		idmap = append(idmap, idrange{i, ix.numName, new})
+	}
+
+	return writeMerged(dst, roots, []mergeSource{{ix, idmap}})
+}
+
+// A mergeSource is an index to be copied into a merged index,
+// along with the map from its fileids to the merged index's fileids.
+type mergeSource struct {
+	ix    *Index
+	idmap []idrange
+}
+
+// writeMerged writes to dst an index with the given roots containing
+// the files from each source, renumbered according to the source's idmap.
+// Together, the idmaps must cover the merged fileids without gaps.
+func writeMerged(dst string, roots []Path, srcs []mergeSource) error {
+	numName := 0
+	for _, src := range srcs {
+		for _, r := range src.idmap {
+			numName += r.hi - r.lo
+		}
+	}
+
+	// Merge only writes version 2 and later.
+	writeVersion = max(writeVersion, 2)
+	ix, err := createBuffer(dst)
+	if err != nil {
+		return err
+	}
+	defer ix.file.Close()
+	switch writeVersion {
+	case 2:
+		ix.WriteString(magicV2)
+	case 3:
+		ix.WriteString(magicV3)
+	default:
+		ix.WriteString(magicV4)
 	}
 
+	// Merged list of paths.
+	pathData := ix.Offset()
+	paths := NewPathWriter(ix, nil, writeVersion, 0)
+	paths.Collect(slices.Values(roots))
+
 	// Merged list of names.
 	ix.Align(16)
 	nameData := ix.Offset()
-	nameIndexFile := bufCreate("")
-	start := ix.Offset()
+	nameIndexFile, err := createBuffer("")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(nameIndexFile.name)
 	names := NewPathWriter(ix, nameIndexFile, writeVersion, nameGroupSize)
-	m1 := map1
-	m2 := map2
+	metaFile, err := createBuffer("")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metaFile.name)
+	maps := make([][]idrange, len(srcs))
+	for i, src := range srcs {
+		maps[i] = src.idmap
+	}
 	for names.Count() != numName {
-		switch {
-		case len(m1) > 0 && m1[0].new == names.Count():
-			names.Collect(ix1.Names(m1[0].lo, m1[0].hi))
-			m1 = m1[1:]
-		case len(m2) > 0 && m2[0].new == names.Count():
-			names.Collect(ix2.Names(m2[0].lo, m2[0].hi))
-			m2 = m2[1:]
-		default:
+		i := slices.IndexFunc(maps, func(m []idrange) bool {
+			return len(m) > 0 && m[0].new == names.Count()
+		})
+		if i < 0 {
 			panic("merge: inconsistent index")
 		}
+		src, r := srcs[i].ix, maps[i][0]
+		names.Collect(src.Names(r.lo, r.hi))
+		src.copyMeta(metaFile, r.lo, r.hi)
+		maps[i] = maps[i][1:]
 	}
-	if writeVersion == 1 {
-		nameIndexFile.WriteUint(ix.Offset() - start)
-		ix.WriteByte(0)
-	}
-
-	var want int
-	if writeVersion == 1 {
-		want = (names.Count() + 1) * 4
-	} else {
-		want = (names.Count() + nameGroupSize - 1) / nameGroupSize * 8
-	}
-	if nameIndexFile.Offset() != want {
+	if nameIndexFile.Offset() != (names.Count()+nameGroupSize-1)/nameGroupSize*8 {
 		panic("merge: inconsistent index")
 	}
 
 	// Merged list of posting lists.
 	ix.Align(16)
 	postData := ix.Offset()
-	var r1 postMapReader
-	var r2 postMapReader
-	var w postDataWriter
-	r1.init(ix1, map1)
-	r2.init(ix2, map2)
-	postIndexFile := bufCreate("")
-	w.init(ix, postIndexFile)
-	old1, old2 := uint32(0), uint32(0)
-	for {
-		if !(r1.trigram > old1 || r2.trigram > old2) {
-			panic("no progress")
-		}
-		old1, old2 = r1.trigram, r2.trigram
-		if r1.trigram < r2.trigram {
-			w.trigram(r1.trigram)
-			for r1.nextId() {
-				w.fileid(r1.fileid)
-			}
-			r1.nextTrigram()
-			w.endTrigram()
-		} else if r2.trigram < r1.trigram {
-			w.trigram(r2.trigram)
-			for r2.nextId() {
-				w.fileid(r2.fileid)
-			}
-			r2.nextTrigram()
-			w.endTrigram()
-		} else {
-			w.trigram(r1.trigram)
-			if r1.trigram == ^uint32(0) {
-				w.endTrigram()
-				break
-			}
-			r1.nextId()
-			r2.nextId()
-			for r1.fileid != -1 || r2.fileid != -1 {
-				if uint(r1.fileid) < uint(r2.fileid) {
-					w.fileid(r1.fileid)
-					r1.nextId()
-				} else if uint(r2.fileid) < uint(r1.fileid) {
-					w.fileid(r2.fileid)
-					r2.nextId()
-				} else {
-					panic("merge: inconsistent index")
-				}
-			}
-			r1.nextTrigram()
-			r2.nextTrigram()
-			w.endTrigram()
-		}
+	var h postHeap
+	for _, src := range srcs {
+		h.addIndex(src.ix, src.idmap)
 	}
-	if len(w.block) > 0 {
-		w.flush()
+	var w postDataWriter
+	postIndexFile, err := createBuffer("")
+	if err != nil {
+		return err
 	}
+	defer os.Remove(postIndexFile.name)
+	w.init(ix, postIndexFile)
+	h.writeTo(&w)
 
 	// Name index
 	ix.Align(16)
 	nameIndex := ix.Offset()
 	copyFile(ix, nameIndexFile)
 
+	// Optional sections
+	var sections []section
+	if writeVersion >= 3 {
+		ix.Align(16)
+		start := ix.Offset()
+		copyFile(ix, metaFile)
+		sections = append(sections, section{sectionFileMeta, start, ix.Offset() - start})
+		ix.Align(16)
+		if sec, ok := w.skips.writeSection(ix); ok {
+			sections = append(sections, sec)
+			ix.Align(16)
+		}
+		if sec, ok := writeRootOptions(ix, roots, mergeRootOptions(srcs)); ok {
+			sections = append(sections, sec)
+			ix.Align(16)
+		}
+		if sec, ok := writePartial(ix, mergePartial(srcs)); ok {
+			sections = append(sections, sec)
+			ix.Align(16)
+		}
+		if sec, ok := writeEncodings(ix, mergeEncodings(srcs)); ok {
+			sections = append(sections, sec)
+			ix.Align(16)
+		}
+		if sec, ok := writeArchives(ix, mergeArchives(srcs)); ok {
+			sections = append(sections, sec)
+		}
+	}
+
 	// Posting list index
 	ix.Align(16)
 	postIndex := ix.Offset()
@@ -256,29 +338,25 @@ func Merge(dst, src1, src2 string) {
 	// Trailer
 	ix.Align(16)
 	ix.WriteUint(pathData)
-	if writeVersion == 2 {
-		ix.WriteUint(paths.Count())
-	}
+	ix.WriteUint(paths.Count())
 	ix.WriteUint(nameData)
-	if writeVersion == 2 {
-		ix.WriteUint(names.Count())
-	}
+	ix.WriteUint(names.Count())
 	ix.WriteUint(postData)
-	if writeVersion == 2 {
-		ix.WriteUint(w.numTrigram)
-	}
+	ix.WriteUint(w.numTrigram)
 	ix.WriteUint(nameIndex)
 	ix.WriteUint(postIndex)
-
-	if writeVersion == 1 {
-		ix.WriteString(trailerMagicV1)
-	} else {
+	switch writeVersion {
+	case 2:
 		ix.WriteString(trailerMagicV2)
+	case 3:
+		writeSections(ix, sections)
+		ix.WriteString(trailerMagicV3)
+	default:
+		writeSections(ix, sections)
+		ix.WriteString(trailerMagicV4)
 	}
 	ix.Flush()
-
-	os.Remove(nameIndexFile.name)
-	os.Remove(w.postIndexFile.name)
+	return ix.err
 }
 
 type postMapReader struct {
@@ -287,6 +365,7 @@ type postMapReader struct {
 	trigram   uint32
 	count     int
 	offset    int
+	enc       int
 	oldid     int
 	fileid    int
 	i         int
@@ -331,27 +410,18 @@ func (r *postMapReader) load(force bool) {
 			b = r.block
 			r.offset = 0
 		}
-		r.trigram = uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
-		b = b[3:]
-		n1, l := binary.Uvarint(b)
-		if l <= 0 {
-			r.ix.corrupt()
-		}
-		b = b[l:]
-		n2, l := binary.Uvarint(b)
-		if l <= 0 {
-			r.ix.corrupt()
-		}
-		b = b[l:]
-		r.count = int(n1)
-		r.offset += int(n2)
-		r.block = b
+		t, count, offset, enc, n := r.ix.postBlockEntry(b)
+		r.trigram = t
+		r.count = count
+		r.offset += offset
+		r.enc = enc
+		r.block = b[n:]
 	}
 	if r.count == 0 {
 		r.fileid = -1
 		return
 	}
-	r.delta.init(r.ix, r.ix.slice(r.ix.postData+r.offset+3, -1))
+	r.delta.initList(r.ix, r.ix.slice(r.ix.postData+r.offset+3, -1), r.enc, r.count)
 	r.oldid = -1
 	r.i = 0
 }
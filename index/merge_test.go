@@ -5,6 +5,10 @@
 package index
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"maps"
 	"os"
 	"slices"
 	"testing"
@@ -49,6 +53,11 @@ func TestMerge(t *testing.T) {
 	out2 := f2.Name()
 	out3 := f3.Name()
 
+	old := writeVersion
+	defer func() {
+		writeVersion = old
+	}()
+
 	writeVersion = 2
 	buildIndex(out1, mergePaths1, mergeFiles1)
 	writeVersion = 1
@@ -76,6 +85,44 @@ func TestMerge(t *testing.T) {
 	checkPosting(t, ix3, "pot", 4, 5, 7)
 }
 
+func TestMergeMeta(t *testing.T) {
+	f1, _ := os.CreateTemp("", "index-test")
+	f2, _ := os.CreateTemp("", "index-test")
+	f3, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f1.Name())
+	defer os.Remove(f2.Name())
+	defer os.Remove(f3.Name())
+
+	out1 := f1.Name()
+	out2 := f2.Name()
+	out3 := f3.Name()
+
+	buildIndex(out1, mergePaths1, mergeFiles1)
+	buildIndex(out2, mergePaths2, mergeFiles2)
+	Merge(out3, out1, out2)
+
+	ix3 := Open(out3)
+	if !ix3.HasMeta() {
+		t.Fatalf("merged index has no file metadata")
+	}
+	checkFiles(t, ix3, "/a/x", "/a/y", "/b/www", "/b/xx", "/b/yy", "/c/ab", "/c/de", "/cc")
+	for i, files := range []map[string]string{
+		mergeFiles1, mergeFiles1, mergeFiles2, mergeFiles2, mergeFiles2, mergeFiles1, mergeFiles1, mergeFiles2,
+	} {
+		name := ix3.Name(i).String()
+		m, ok := ix3.Meta(i)
+		if !ok {
+			t.Fatalf("Meta(%d) missing", i)
+		}
+		if want := sha256.Sum256([]byte(files[name])); m.Size != int64(len(files[name])) || m.Hash != want {
+			t.Errorf("Meta(%d) = size %d hash %x, want size %d hash %x", i, m.Size, m.Hash, len(files[name]), want)
+		}
+	}
+	if err := ix3.Check(); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func checkFiles(t *testing.T, ix *Index, l ...string) {
 	t.Helper()
 	for i, s := range l {
@@ -92,3 +139,74 @@ func checkPosting(t *testing.T, ix *Index, trig string, l ...int) {
 		t.Errorf("PostingList(%q) = %v, want %v", trig, l1, l)
 	}
 }
+
+func TestMergeReplaceAll(t *testing.T) {
+	f1, _ := os.CreateTemp("", "index-test")
+	f2, _ := os.CreateTemp("", "index-test")
+	f3, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f1.Name())
+	defer os.Remove(f2.Name())
+	defer os.Remove(f3.Name())
+
+	out1 := f1.Name()
+	out2 := f2.Name()
+	out3 := f3.Name()
+
+	// Replacing every root in an index should produce
+	// exactly the index being merged in.
+	roots := []string{"/a", "/b", "/c", "/cc"}
+	buildIndex(out1, roots, mergeFiles1)
+	buildIndex(out2, roots, mergeFiles2)
+	Merge(out3, out1, out2)
+
+	data2, _ := os.ReadFile(out2)
+	data3, _ := os.ReadFile(out3)
+	if !bytes.Equal(data2, data3) {
+		t.Fatalf("merged index differs:\nhave:\n%s\nwant:\n%s", hex.Dump(data3), hex.Dump(data2))
+	}
+}
+
+func TestRemove(t *testing.T) {
+	f1, _ := os.CreateTemp("", "index-test")
+	f2, _ := os.CreateTemp("", "index-test")
+	f3, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f1.Name())
+	defer os.Remove(f2.Name())
+	defer os.Remove(f3.Name())
+
+	out1 := f1.Name()
+	out2 := f2.Name()
+	out3 := f3.Name()
+
+	buildIndex(out1, mergePaths1, mergeFiles1)
+	if err := Remove(out2, out1, []Path{MakePath("/b")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Remove(out3, out1, []Path{MakePath("/d")}); err == nil {
+		t.Fatalf("Remove(/d) succeeded, want error")
+	}
+
+	ix2 := Open(out2)
+	if roots := slices.Collect(ix2.Roots().All()); !slices.Equal(roots, []Path{MakePath("/a"), MakePath("/c")}) {
+		t.Errorf("Roots() = %v, want [/a /c]", roots)
+	}
+	checkFiles(t, ix2, "/a/x", "/a/y", "/c/ab", "/c/de")
+	checkPosting(t, ix2, "wor", 0, 1)
+	checkPosting(t, ix2, "now", 3)
+	checkPosting(t, ix2, "all", 2)
+	checkPosting(t, ix2, "tim")
+	if err := ix2.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing roots should match indexing only the remaining ones.
+	files := maps.Clone(mergeFiles1)
+	delete(files, "/b/xx")
+	delete(files, "/b/xy")
+	buildIndex(out3, []string{"/a", "/c"}, files)
+	data2, _ := os.ReadFile(out2)
+	data3, _ := os.ReadFile(out3)
+	if !bytes.Equal(data2, data3) {
+		t.Fatalf("index after Remove differs:\nhave:\n%s\nwant:\n%s", hex.Dump(data2), hex.Dump(data3))
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"time"
+
+	"github.com/google/codesearch/transcode"
+)
+
+// Optional sections and per-file metadata.
+// See read.go for details of the on-disk format.
+
+const (
+	sectionEntrySize = 8 + 8 + 8
+	sectionFileMeta  = "filemeta"
+
+	metaRecordSize = 8 + 8 + sha256.Size
+)
+
+// A section describes an optional section of a version 3 index.
+type section struct {
+	name   string
+	offset int
+	size   int
+}
+
+// sectionEntry returns the section entry at the given offset in the trailer.
+func (ix *Index) sectionEntry(off int) section {
+	name := strings.TrimRight(string(ix.slice(off, 8)), "\x00")
+	sec := section{name: name, offset: ix.uint64(off + 8), size: ix.uint64(off + 16)}
+	if sec.offset+sec.size < sec.offset || sec.offset+sec.size > len(ix.data.d) {
+		ix.corrupt()
+	}
+	return sec
+}
+
+// section returns the optional section with the given name.
+func (ix *Index) section(name string) (section, bool) {
+	for _, sec := range ix.sections {
+		if sec.name == name {
+			return sec, true
+		}
+	}
+	return section{}, false
+}
+
+// writeSections writes the optional section entries
+// and their count to the trailer in b.
+func writeSections(b *Buffer, sections []section) {
+	for _, sec := range sections {
+		var name [8]byte
+		if len(sec.name) > len(name) {
+			panic("section name too long")
+		}
+		copy(name[:], sec.name)
+		b.Write(name[:])
+		b.WriteUint(sec.offset)
+		b.WriteUint(sec.size)
+	}
+	b.WriteUint(len(sections))
+}
+
+// A FileMeta is the metadata recorded for an indexed file.
+type FileMeta struct {
+	Size    int64             // size in bytes
+	ModTime time.Time         // modification time; zero if unknown
+	Hash    [sha256.Size]byte // SHA-256 hash of content
+
+	// Encoding is the encoding of the content,
+	// which was decoded to UTF-8 for indexing.
+	Encoding transcode.Encoding
+}
+
+// HasMeta reports whether the index records file metadata.
+func (ix *Index) HasMeta() bool {
+	return ix.metaSize > 0
+}
+
+// Meta returns the metadata recorded for the file with the given fileid.
+// It returns false if the index does not record file metadata.
+func (ix *Index) Meta(fileid int) (FileMeta, bool) {
+	if ix.metaSize == 0 || fileid < 0 || fileid >= ix.numName {
+		return FileMeta{}, false
+	}
+	d := ix.slice(ix.metaData+fileid*ix.metaSize, metaRecordSize)
+	var m FileMeta
+	m.Size = int64(binary.BigEndian.Uint64(d))
+	if t := int64(binary.BigEndian.Uint64(d[8:])); t != 0 {
+		m.ModTime = time.Unix(0, t)
+	}
+	copy(m.Hash[:], d[16:])
+	m.Encoding = ix.encoding(fileid)
+	return m, true
+}
+
+// appendMeta appends the on-disk encoding of m to b.
+func appendMeta(b []byte, m *FileMeta) []byte {
+	b = binary.BigEndian.AppendUint64(b, uint64(m.Size))
+	var t int64
+	if !m.ModTime.IsZero() {
+		t = m.ModTime.UnixNano()
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(t))
+	return append(b, m.Hash[:]...)
+}
+
+// copyMeta writes the metadata records for fileids [lo, hi) to w.
+// If ix does not record metadata, copyMeta writes zeroed records.
+func (ix *Index) copyMeta(w *Buffer, lo, hi int) {
+	if ix.metaSize == metaRecordSize {
+		w.Write(ix.slice(ix.metaData+lo*metaRecordSize, (hi-lo)*metaRecordSize))
+		return
+	}
+	var zero [metaRecordSize]byte
+	for id := lo; id < hi; id++ {
+		if ix.metaSize == 0 {
+			w.Write(zero[:])
+			continue
+		}
+		w.Write(ix.slice(ix.metaData+id*ix.metaSize, metaRecordSize))
+	}
+}
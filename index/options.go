@@ -0,0 +1,113 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/binary"
+	"maps"
+	"slices"
+)
+
+// Per-root indexing options.
+// See read.go for details of the on-disk format.
+
+const sectionRootOpts = "rootopts"
+
+// SetRootOptions records opts as the options used to index root,
+// which should be one of the roots added with AddRoots.
+// The index package does not interpret the options; they are
+// saved so that the program that indexed root can index it the
+// same way again. They are typically command-line flags.
+func (ix *IndexWriter) SetRootOptions(root Path, opts []string) {
+	if ix.rootOpts == nil {
+		ix.rootOpts = make(map[Path][]string)
+	}
+	ix.rootOpts[root] = slices.Clone(opts)
+}
+
+// RootOptions returns the options recorded for root
+// by IndexWriter.SetRootOptions, or nil if there are none.
+func (ix *Index) RootOptions(root Path) []string {
+	return ix.rootOptions()[root]
+}
+
+// rootOptions returns the options for all roots that have them.
+func (ix *Index) rootOptions() map[Path][]string {
+	sec, ok := ix.section(sectionRootOpts)
+	if !ok {
+		return nil
+	}
+	data := ix.slice(sec.offset, sec.size)
+	str := func() string {
+		n, k := binary.Uvarint(data)
+		if k <= 0 || n > uint64(len(data)-k) {
+			ix.corrupt()
+		}
+		s := string(data[k : k+int(n)])
+		data = data[k+int(n):]
+		return s
+	}
+	m := make(map[Path][]string)
+	for len(data) > 0 {
+		root := MakePath(str())
+		n, k := binary.Uvarint(data)
+		if k <= 0 || n > uint64(len(data)) {
+			ix.corrupt()
+		}
+		data = data[k:]
+		opts := make([]string, n)
+		for i := range opts {
+			opts[i] = str()
+		}
+		m[root] = opts
+	}
+	return m
+}
+
+// RootOptions returns the options recorded for root in the first
+// shard that has root as one of its roots, or nil if there are none.
+func (s *Set) RootOptions(root Path) []string {
+	for _, ix := range s.shards {
+		for r := range ix.Roots().All() {
+			if r == root {
+				return ix.RootOptions(root)
+			}
+		}
+	}
+	return nil
+}
+
+// writeRootOptions writes the options in opts for the given roots to out,
+// reporting false if none of the roots have options.
+func writeRootOptions(out *Buffer, roots []Path, opts map[Path][]string) (section, bool) {
+	if !slices.ContainsFunc(roots, func(root Path) bool { return opts[root] != nil }) {
+		return section{}, false
+	}
+	start := out.Offset()
+	for _, root := range roots {
+		o, ok := opts[root]
+		if !ok {
+			continue
+		}
+		out.WriteVarint(len(root.String()))
+		out.WriteString(root.String())
+		out.WriteVarint(len(o))
+		for _, s := range o {
+			out.WriteVarint(len(s))
+			out.WriteString(s)
+		}
+	}
+	return section{sectionRootOpts, start, out.Offset() - start}, true
+}
+
+// mergeRootOptions returns the root options for an index merging
+// the given sources, with later sources taking precedence.
+func mergeRootOptions(srcs []mergeSource) map[Path][]string {
+	m := make(map[Path][]string)
+	for _, src := range srcs {
+		maps.Copy(m, src.ix.rootOptions())
+	}
+	return m
+}
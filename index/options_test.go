@@ -0,0 +1,74 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// rootOptsConfig returns a configuration for buildFlushIndex
+// that records opts for the roots.
+func rootOptsConfig(opts map[string][]string) func(*IndexWriter) {
+	return func(ix *IndexWriter) {
+		for root, o := range opts {
+			ix.SetRootOptions(MakePath(root), o)
+		}
+	}
+}
+
+func checkRootOptions(t *testing.T, ix *Index, want map[string][]string) {
+	t.Helper()
+	for root := range ix.Roots().All() {
+		if have := ix.RootOptions(root); !slices.Equal(have, want[root.String()]) {
+			t.Errorf("RootOptions(%s) = %q, want %q", root, have, want[root.String()])
+		}
+	}
+}
+
+func TestRootOptions(t *testing.T) {
+	dir := t.TempDir()
+	out1 := filepath.Join(dir, "index1")
+	out2 := filepath.Join(dir, "index2")
+	out3 := filepath.Join(dir, "index3")
+	out4 := filepath.Join(dir, "index4")
+
+	opts1 := map[string][]string{
+		"/a": {"-zip"},
+		"/b": {"-zip", "-maxfile=100"},
+	}
+	buildFlushIndex(out1, mergePaths1, false, mergeFiles1, rootOptsConfig(opts1))
+	buildFlushIndex(out2, mergePaths2, false, mergeFiles2, rootOptsConfig(map[string][]string{"/b": {}}))
+
+	ix1 := Open(out1)
+	checkRootOptions(t, ix1, opts1)
+	if err := ix1.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The newer index's options take precedence in a merge,
+	// even when they are empty.
+	Merge(out3, out1, out2)
+	checkRootOptions(t, Open(out3), map[string][]string{
+		"/a": {"-zip"},
+		"/b": {},
+	})
+
+	if err := Remove(out4, out1, []Path{MakePath("/a")}); err != nil {
+		t.Fatal(err)
+	}
+	ix4 := Open(out4)
+	checkRootOptions(t, ix4, map[string][]string{"/b": {"-zip", "-maxfile=100"}})
+	if o := OpenSetList([]string{out2, out4}).RootOptions(MakePath("/b")); o == nil || len(o) != 0 {
+		t.Errorf("Set.RootOptions(/b) = %q, want []", o)
+	}
+
+	// An index without options has no rootopts section.
+	buildIndex(out1, mergePaths1, mergeFiles1)
+	if _, ok := Open(out1).section(sectionRootOpts); ok {
+		t.Errorf("index without root options has %s section", sectionRootOpts)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"io/fs"
+	"log"
+	"slices"
+)
+
+// Concurrent file reading.
+//
+// When ix.Workers > 1, AddFile opens each file and queues it for a pool
+// of worker goroutines, each with its own trigramReader, and returns
+// without waiting for the file to be read. The files are added to the
+// index in the order they were queued, once their trigrams are ready,
+// so the file IDs, and the index, are the same as when AddFile reads
+// each file itself. Anything else that adds to the index (Add, reuse,
+// Flush) first waits for the queued files.
+
+// A fileJob is a file queued for reading by a worker.
+type fileJob struct {
+	name    string
+	f       fs.File
+	limits  Limits
+	partial bool
+	done    chan struct{} // closed when t and err are set
+	t       fileTrigrams
+	err     error
+}
+
+// addAsync queues the file f, with the given name, to be read by a worker
+// and then added to the index. The worker closes f.
+func (ix *IndexWriter) addAsync(name string, f fs.File) {
+	if ix.work == nil {
+		ix.work = make(chan *fileJob, ix.Workers)
+		for range ix.Workers {
+			go readFiles(ix.work)
+		}
+	}
+	// Limit the number of files read but not yet added,
+	// which holds down memory use and open files.
+	for len(ix.queue) >= 2*ix.Workers {
+		ix.finish()
+	}
+	job := &fileJob{name: name, f: f, limits: ix.limitsFor(name), partial: ix.partialOK(), done: make(chan struct{})}
+	ix.queue = append(ix.queue, job)
+	ix.work <- job
+}
+
+// readFiles is the loop run by each worker goroutine.
+func readFiles(work <-chan *fileJob) {
+	r := newTrigramReader()
+	for job := range work {
+		job.t, job.err = r.read(job.name, job.f, job.limits, job.partial)
+		job.t.meta.ModTime = modTime(job.f)
+		if job.t.skip != 0 {
+			job.t.meta.Size = fileSize(job.f, job.t.meta.Size)
+		}
+		job.t.trigrams = slices.Clone(job.t.trigrams)
+		job.f.Close()
+		close(job.done)
+	}
+}
+
+// finish waits for the first queued file to be read
+// and adds it to the index.
+func (ix *IndexWriter) finish() {
+	job := ix.queue[0]
+	ix.queue[0] = nil
+	ix.queue = ix.queue[1:]
+	<-job.done
+	if job.err != nil {
+		log.Printf("%s: %v", job.name, job.err)
+		return
+	}
+	ix.addTrigrams(job.name, &job.t)
+}
+
+// wait adds all the queued files to the index.
+func (ix *IndexWriter) wait() {
+	for len(ix.queue) > 0 {
+		ix.finish()
+	}
+}
+
+// stopWorkers adds all the queued files to the index
+// and stops the worker goroutines.
+func (ix *IndexWriter) stopWorkers() {
+	ix.wait()
+	if ix.work != nil {
+		close(ix.work)
+		ix.work = nil
+	}
+}
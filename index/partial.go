@@ -0,0 +1,118 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"slices"
+)
+
+// Partially indexed files.
+// See read.go for details of the on-disk format.
+//
+// A partially indexed file's posting lists may be missing trigrams
+// that appear in the file, so a query cannot rule it out. Queries
+// therefore treat every partially indexed file as a candidate, leaving
+// it to the caller to scan the whole file, as it does for any candidate.
+
+const sectionPartial = "partial"
+
+// partialOK reports whether ix can index files partially.
+// Only indexes with optional sections can record which files are partial.
+func (ix *IndexWriter) partialOK() bool {
+	return ix.Partial && writeVersion >= 3
+}
+
+// initPartial reads the list of partially indexed files in sec.
+func (ix *Index) initPartial(sec section) {
+	if sec.size%8 != 0 {
+		ix.corrupt()
+	}
+	ix.partial = make([]int, sec.size/8)
+	last := -1
+	for i := range ix.partial {
+		id := ix.uint64(sec.offset + 8*i)
+		if id <= last || id >= ix.numName {
+			ix.corrupt()
+		}
+		ix.partial[i] = id
+		last = id
+	}
+}
+
+// Partial reports whether the file with the given fileid was only
+// partially indexed, because it exceeded the IndexWriter's limits.
+func (ix *Index) Partial(fileid int) bool {
+	_, ok := slices.BinarySearch(ix.partial, fileid)
+	return ok
+}
+
+// addPartial returns the union of the sorted fileid list
+// and the partially indexed files.
+func (ix *Index) addPartial(list []int) []int {
+	if len(ix.partial) == 0 {
+		return list
+	}
+	out := make([]int, 0, len(list)+len(ix.partial))
+	i, j := 0, 0
+	for i < len(list) || j < len(ix.partial) {
+		switch {
+		case j == len(ix.partial) || i < len(list) && list[i] < ix.partial[j]:
+			out = append(out, list[i])
+			i++
+		case i == len(list) || ix.partial[j] < list[i]:
+			out = append(out, ix.partial[j])
+			j++
+		default:
+			out = append(out, list[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// A sliceIter is a postIter for a sorted list of file IDs.
+type sliceIter []int
+
+func (it *sliceIter) seek(id int) int {
+	i, _ := slices.BinarySearch(*it, id)
+	*it = (*it)[i:]
+	if len(*it) == 0 {
+		return -1
+	}
+	return (*it)[0]
+}
+
+// writePartial writes the list of partially indexed files to out,
+// reporting false if the list is empty.
+func writePartial(out *Buffer, ids []int) (section, bool) {
+	if len(ids) == 0 {
+		return section{}, false
+	}
+	start := out.Offset()
+	for _, id := range ids {
+		out.WriteUint(id)
+	}
+	return section{sectionPartial, start, out.Offset() - start}, true
+}
+
+// mergePartial returns the partially indexed files
+// in an index merging the given sources.
+func mergePartial(srcs []mergeSource) []int {
+	var ids []int
+	for _, src := range srcs {
+		for _, r := range src.idmap {
+			lo, _ := slices.BinarySearch(src.ix.partial, r.lo)
+			for _, id := range src.ix.partial[lo:] {
+				if id >= r.hi {
+					break
+				}
+				ids = append(ids, r.new+id-r.lo)
+			}
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var partialFiles = map[string]string{
+	"/p/a.txt":   "hello world\n",
+	"/p/big.log": strings.Repeat("log line\n", 30) + "needle at the end\n",
+	"/p/long.js": "function f() {}\n" + strings.Repeat("x", 100) + "needle\n" + "var tail = 1;\n",
+	"/p/words":   "apple banana cherry damson elderberry fig grape needle\n",
+	"/p/zzz.txt": "goodbye world\n",
+}
+
+// partialConfig returns a configuration for buildFlushIndex
+// with low limits, indexing files exceeding them partially if partial is set.
+func partialConfig(partial bool) func(*IndexWriter) {
+	return func(ix *IndexWriter) {
+		ix.Limits = Limits{MaxFileLen: 200, MaxLineLen: 50}
+		ix.LimitOverrides = []LimitOverride{{Pattern: "words", Limits: Limits{MaxTextTrigrams: 20}}}
+		ix.Partial = partial
+	}
+}
+
+func TestPartial(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "index")
+	buildFlushIndex(out, []string{"/p"}, false, partialFiles, partialConfig(true))
+	ix := Open(out)
+	if err := ix.Check(); err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, ix, "/p/a.txt", "/p/big.log", "/p/long.js", "/p/words", "/p/zzz.txt")
+	var partial []int
+	for id := range ix.numName {
+		if ix.Partial(id) {
+			partial = append(partial, id)
+		}
+	}
+	if !slices.Equal(partial, []int{1, 2, 3}) {
+		t.Errorf("partial files = %v, want [1 2 3]", partial)
+	}
+
+	// The meta records the whole file, not just the indexed part.
+	if m, _ := ix.Meta(1); m.Size != int64(len(partialFiles["/p/big.log"])) {
+		t.Errorf("Meta(big.log).Size = %d, want %d", m.Size, len(partialFiles["/p/big.log"]))
+	}
+
+	// Trigrams within the limits are indexed; the rest are not,
+	// but the partial files are candidates for every query.
+	checkPosting(t, ix, "log", 1)
+	checkPosting(t, ix, "tai", 2)
+	checkPosting(t, ix, "nee")
+	for _, tt := range []struct {
+		q    *Query
+		want []int
+	}{
+		{&Query{Op: QAnd, Trigram: []string{"nee", "edl"}}, []int{1, 2, 3}},
+		{&Query{Op: QAnd, Trigram: []string{"wor"}}, []int{0, 1, 2, 3, 4}},
+		{&Query{Op: QOr, Trigram: []string{"bye", "tai"}}, []int{1, 2, 3, 4}},
+	} {
+		if have := ix.PostingQuery(tt.q); !slices.Equal(have, tt.want) {
+			t.Errorf("PostingQuery(%s) = %v, want %v", tt.q, have, tt.want)
+		}
+		if have := slices.Collect(ix.PostingQuerySeq(tt.q)); !slices.Equal(have, tt.want) {
+			t.Errorf("PostingQuerySeq(%s) = %v, want %v", tt.q, have, tt.want)
+		}
+	}
+	if have := ix.PostingQuery(&Query{Op: QNone}); len(have) != 0 {
+		t.Errorf("PostingQuery(none) = %v, want none", have)
+	}
+
+	// Without Partial, the files are skipped.
+	out2 := filepath.Join(dir, "index2")
+	buildFlushIndex(out2, []string{"/p"}, false, partialFiles, partialConfig(false))
+	checkFiles(t, Open(out2), "/p/a.txt", "/p/zzz.txt")
+
+	// Merging keeps track of the partial files.
+	out3 := filepath.Join(dir, "index3")
+	buildIndex(out3, []string{"/a", "/q"}, map[string]string{"/a/x": "hello", "/q/y": "world"})
+	out4 := filepath.Join(dir, "index4")
+	Merge(out4, out3, out)
+	ix4 := Open(out4)
+	checkFiles(t, ix4, "/a/x", "/p/a.txt", "/p/big.log", "/p/long.js", "/p/words", "/p/zzz.txt", "/q/y")
+	if have := ix4.partial; !slices.Equal(have, []int{2, 3, 4}) {
+		t.Errorf("merged partial files = %v, want [2 3 4]", have)
+	}
+	out5 := filepath.Join(dir, "index5")
+	if err := Remove(out5, out4, []Path{MakePath("/a")}); err != nil {
+		t.Fatal(err)
+	}
+	if have := Open(out5).partial; !slices.Equal(have, []int{1, 2, 3}) {
+		t.Errorf("partial files after Remove = %v, want [1 2 3]", have)
+	}
+}
@@ -69,7 +69,7 @@ type PathWriter struct {
 }
 
 func NewPathWriter(data, index *Buffer, version, group int) *PathWriter {
-	if version != 1 && version != 2 {
+	if version < 1 || version > 4 {
 		panic("bad PathWriter version")
 	}
 	return &PathWriter{
@@ -129,7 +129,7 @@ type PathReader struct {
 }
 
 func NewPathReader(version int, data []byte, limit int) *PathReader {
-	if version != 1 && version != 2 {
+	if version < 1 || version > 4 {
 		panic("bad PathWriter version")
 	}
 	r := &PathReader{
@@ -0,0 +1,249 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Posting list encodings.
+// See read.go for details of the on-disk format.
+
+const (
+	encGamma  = 0 // γ-coded deltas ending with a zero delta
+	encBitmap = 1 // bitmap of file IDs
+	encBlock  = 2 // blocks of bit-packed deltas
+
+	blockLen = 128 // deltas per block in encBlock lists
+)
+
+// chooseEncoding returns the encoding to use for the posting list ids,
+// which must be non-empty. It picks a bitmap for dense lists,
+// where a bitmap is no larger than the γ coding, and blocks for
+// lists where blocks are close in size to the γ coding, since they
+// are faster to decode. Otherwise it picks the γ coding.
+func chooseEncoding(ids []int) int {
+	gamma := 9 // terminating zero delta, encoded as deltaZeroEnc
+	last := -1
+	for _, id := range ids {
+		x := id - last
+		if x >= deltaZeroEnc {
+			x++
+		}
+		gamma += 2*bits.Len(uint(x)) - 1
+		last = id
+	}
+	gamma = (gamma + 7) / 8
+
+	span := (ids[len(ids)-1] - ids[0] + 8) / 8
+	bitmap := uvarintLen(ids[0]) + uvarintLen(span) + span
+
+	block := 0
+	last = -1
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), blockLen)]
+		ids = ids[len(chunk):]
+		w := blockWidth(chunk, last)
+		block += 1 + (len(chunk)*w+7)/8
+		last = chunk[len(chunk)-1]
+	}
+
+	switch {
+	case bitmap <= gamma:
+		return encBitmap
+	case block <= gamma+gamma/8:
+		return encBlock
+	}
+	return encGamma
+}
+
+func uvarintLen(x int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(x))
+}
+
+// blockWidth returns the number of bits needed to store
+// each delta-1 in the block ids, which follows last.
+func blockWidth(ids []int, last int) int {
+	var or uint
+	for _, id := range ids {
+		or |= uint(id - last - 1)
+		last = id
+	}
+	return bits.Len(or)
+}
+
+// writeBitmap writes the non-empty posting list ids to out as a bitmap.
+func writeBitmap(out *Buffer, ids []int) {
+	base := ids[0]
+	bitmap := make([]byte, (ids[len(ids)-1]-base+8)/8)
+	for _, id := range ids {
+		bitmap[(id-base)/8] |= 1 << ((id - base) % 8)
+	}
+	out.WriteVarint(base)
+	out.WriteVarint(len(bitmap))
+	out.Write(bitmap)
+}
+
+// writeBlock writes the block ids, which follows last, to out.
+func writeBlock(out *Buffer, ids []int, last int) {
+	w := blockWidth(ids, last)
+	out.WriteByte(byte(w))
+	var b byte
+	nb := 0
+	for _, id := range ids {
+		x := uint64(id - last - 1)
+		last = id
+		for left := w; left > 0; {
+			k := min(left, 8-nb)
+			b |= byte(x&(1<<k-1)) << nb
+			x >>= k
+			left -= k
+			nb += k
+			if nb == 8 {
+				out.WriteByte(b)
+				b, nb = 0, 0
+			}
+		}
+	}
+	if nb > 0 {
+		out.WriteByte(b)
+	}
+}
+
+// initList initializes r to read the deltas of the posting list
+// with the given encoding and count, whose data (after the trigram)
+// begins at the start of data.
+func (r *deltaReader) initList(ix *Index, data []byte, enc, count int) {
+	r.init(ix, data)
+	r.enc = enc
+	r.n = count
+	r.block = nil
+	switch enc {
+	case encGamma, encBlock:
+		// nothing more
+	case encBitmap:
+		base, n1 := binary.Uvarint(data)
+		if n1 <= 0 {
+			ix.corrupt()
+		}
+		size, n2 := binary.Uvarint(data[n1:])
+		if n2 <= 0 || uint64(len(data)-n1-n2) < size {
+			ix.corrupt()
+		}
+		r.base = int(base)
+		r.bits = data[n1+n2 : n1+n2+int(size)]
+		r.pos = 0
+		r.prev = -r.base - 1
+	default:
+		ix.corrupt()
+	}
+}
+
+// nextBitmap returns the next delta from a bitmap list.
+func (r *deltaReader) nextBitmap() int {
+	if r.n == 0 {
+		return 0
+	}
+	for {
+		i := r.pos / 8
+		if i >= len(r.bits) {
+			r.ix.corrupt()
+		}
+		if b := r.bits[i] >> (r.pos % 8); b != 0 {
+			r.pos += bits.TrailingZeros8(b)
+			break
+		}
+		r.pos = (i + 1) * 8
+	}
+	delta := r.pos - r.prev
+	r.prev = r.pos
+	r.pos++
+	r.n--
+	return delta
+}
+
+// skipBitmap advances a bitmap reader so that the next delta
+// leads to the first file ID >= fileid.
+// It returns the number of file IDs skipped.
+func (r *deltaReader) skipBitmap(fileid int) int {
+	to := min(fileid-r.base, len(r.bits)*8)
+	k := 0
+	for r.pos < to {
+		if r.pos%8 == 0 && r.pos+8 <= to {
+			k += bits.OnesCount8(r.bits[r.pos/8])
+			r.pos += 8
+			continue
+		}
+		k += int(r.bits[r.pos/8] >> (r.pos % 8) & 1)
+		r.pos++
+	}
+	if k > r.n {
+		r.ix.corrupt()
+	}
+	r.n -= k
+	return k
+}
+
+// nextBlock returns the next delta from a block list.
+func (r *deltaReader) nextBlock() int {
+	if len(r.block) == 0 {
+		if r.n == 0 {
+			return 0
+		}
+		m := min(r.n, blockLen)
+		if len(r.d) == 0 {
+			r.ix.corrupt()
+		}
+		w := int(r.d[0])
+		size := (m*w + 7) / 8
+		if w > 64 || len(r.d) < 1+size {
+			r.ix.corrupt()
+		}
+		d := r.d[1 : 1+size]
+		r.d = r.d[1+size:]
+		r.n -= m
+		var b byte
+		nb := 0
+		for i := range m {
+			var x uint64
+			for got := 0; got < w; {
+				if nb == 0 {
+					b, nb = d[0], 8
+					d = d[1:]
+				}
+				k := min(w-got, nb)
+				x |= uint64(b&(1<<k-1)) << got
+				b >>= k
+				nb -= k
+				got += k
+			}
+			r.buf[i] = int(x) + 1
+		}
+		r.block = r.buf[:m]
+	}
+	delta := r.block[0]
+	r.block = r.block[1:]
+	return delta
+}
+
+// seekList positions r at the given bit offset in the list data,
+// as recorded in a skip table, with n deltas left in the list.
+func (r *deltaReader) seekList(data []byte, bit, n int) {
+	switch r.enc {
+	case encGamma:
+		r.seekBit(data, bit)
+	case encBlock:
+		if bit%8 != 0 {
+			r.ix.corrupt()
+		}
+		r.d = data[bit/8:]
+		r.block = nil
+		r.n = n
+	default:
+		r.ix.corrupt()
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+var encodingTests = []struct {
+	name string
+	ids  func(r *rand.Rand) []int
+	enc  int
+}{
+	{"dense", func(r *rand.Rand) []int { return randomList(r, 1000, 2) }, encBitmap},
+	{"regular", func(r *rand.Rand) []int { return randomList(r, 1000, 1<<10) }, encBlock},
+	{"skewed", func(r *rand.Rand) []int { return skewedList(r, 1000) }, encGamma},
+	{"wide", func(r *rand.Rand) []int { return randomList(r, 300, 1<<40) }, encBlock},
+	{"single", func(r *rand.Rand) []int { return []int{12345} }, encBitmap},
+}
+
+// randomList returns n increasing file IDs
+// whose deltas are uniformly distributed in [1, max].
+func randomList(r *rand.Rand, n, max int) []int {
+	var ids []int
+	id := -1
+	for range n {
+		id += 1 + r.Intn(max)
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// skewedList returns n increasing file IDs
+// with mostly small deltas and occasional large ones.
+func skewedList(r *rand.Rand, n int) []int {
+	var ids []int
+	id := -1
+	for i := range n {
+		if i%50 == 0 {
+			id += 1 + r.Intn(1<<20)
+		} else {
+			id += 1 + r.Intn(2)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestEncodings(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range encodingTests {
+		ids := tt.ids(r)
+		if enc := chooseEncoding(ids); enc != tt.enc {
+			t.Errorf("%s: chooseEncoding = %d, want %d", tt.name, enc, tt.enc)
+		}
+		for _, enc := range []int{encGamma, encBitmap, encBlock} {
+			if enc == encBitmap && ids[len(ids)-1] > 1<<24 {
+				continue
+			}
+			data := writeList(enc, ids)
+			ix := &Index{version: 4}
+			var dr deltaReader
+			dr.initList(ix, data, enc, len(ids))
+			var have []int
+			id := -1
+			for range ids {
+				id += dr.next()
+				have = append(have, id)
+			}
+			if d := dr.next(); d != 0 {
+				t.Errorf("%s/%d: list does not end with zero delta (%d)", tt.name, enc, d)
+			}
+			if !slices.Equal(have, ids) {
+				t.Errorf("%s/%d: decoded list differs", tt.name, enc)
+			}
+		}
+	}
+}
+
+func TestSkipBitmap(t *testing.T) {
+	ids := []int{10, 11, 13, 20, 21, 22, 40, 47, 48, 100}
+	data := writeList(encBitmap, ids)
+	var dr deltaReader
+	dr.initList(&Index{version: 4}, data, encBitmap, len(ids))
+	if d := dr.next(); d != 11 {
+		t.Fatalf("first delta = %d, want 11", d)
+	}
+	if k := dr.skipBitmap(40); k != 5 {
+		t.Fatalf("skipBitmap(40) skipped %d, want 5", k)
+	}
+	if d := dr.next(); d != 30 {
+		t.Fatalf("delta after skip = %d, want 30", d)
+	}
+	if k := dr.skipBitmap(1000); k != 3 || dr.next() != 0 {
+		t.Fatalf("skipBitmap(1000) skipped %d, want 3", k)
+	}
+}
+
+// writeList returns the data for the list ids in the given encoding.
+func writeList(enc int, ids []int) []byte {
+	b := bufCreate("")
+	defer os.Remove(b.name)
+	switch enc {
+	case encGamma:
+		var w deltaWriter
+		w.init(b)
+		last := -1
+		for _, id := range ids {
+			w.Write(id - last)
+			last = id
+		}
+		w.Write(0)
+		w.Flush()
+	case encBitmap:
+		writeBitmap(b, ids)
+	case encBlock:
+		last := -1
+		for len(ids) > 0 {
+			block := ids[:min(len(ids), blockLen)]
+			ids = ids[len(block):]
+			writeBlock(b, block, last)
+			last = block[len(block)-1]
+		}
+	}
+	data, err := io.ReadAll(b.finish())
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func TestLongListFallback(t *testing.T) {
+	old := maxBufferedIDs
+	defer func() { maxBufferedIDs = old }()
+	maxBufferedIDs = 100
+
+	files := make(map[string]string)
+	var all, some []int
+	for i := range 300 {
+		data := "abc"
+		if i < 50 {
+			data += " xyz"
+			some = append(some, i)
+		}
+		files[fmt.Sprintf("/a/f%03d", i)] = data
+		all = append(all, i)
+	}
+	out := filepath.Join(t.TempDir(), "index")
+	buildIndex(out, []string{"/a"}, files)
+	ix := Open(out)
+	if err := ix.Check(); err != nil {
+		t.Fatal(err)
+	}
+	checkPosting(t, ix, "abc", all...)
+	checkPosting(t, ix, "xyz", some...)
+
+	// The list too long to buffer is γ-coded;
+	// the short one has the encoding chosen for it.
+	if _, _, enc := ix.findListEnc(tri("abc")); enc != encGamma {
+		t.Errorf("long list encoding = %d, want %d", enc, encGamma)
+	}
+	if _, _, enc := ix.findListEnc(tri("xyz")); enc != chooseEncoding(some) {
+		t.Errorf("short list encoding = %d, want %d", enc, chooseEncoding(some))
+	}
+}
@@ -8,11 +8,12 @@ package index
 //
 // An index stored on disk has the format:
 //
-//	"csearch index 2\n"
+//	"csearch index 4\n"
 //	list of roots
 //	list of names
 //	list of posting lists
 //	name index
+//	optional sections
 //	posting list index
 //	trailer
 //
@@ -32,17 +33,41 @@ package index
 // Each posting list has the form:
 //
 //	trigram [3]
-//	deltas [v]...
-//
-// The trigram gives the 3 byte trigram that this list describes.  The
-// delta list is a sequence of [γ-coded] deltas between file IDs,
-// ending with a zero delta.  For example, the delta list [2,5,1,1,0]
-// encodes the file ID list 1, 6, 7, 8.  The delta list [0] would
-// encode the empty file ID list, but empty posting lists are usually
-// not recorded at all.  The list of posting lists ends with an entry
-// with trigram "\xff\xff\xff" and a delta list consisting a single zero.
-// In the γ-encoding, which cannot represent 0, 0 encodes as 31,
-// and all values v ≥ 31 encode as v+1.
+//	file IDs
+//
+// The trigram gives the 3 byte trigram that this list describes.
+// The file IDs are encoded in one of three ways, recorded in the
+// list's posting list index entry:
+//
+// Encoding 0 is a delta list: a sequence of [γ-coded] deltas between
+// file IDs, ending with a zero delta.  For example, the delta list
+// [2,5,1,1,0] encodes the file ID list 1, 6, 7, 8.  The delta list [0]
+// would encode the empty file ID list, but empty posting lists are
+// usually not recorded at all.  The list of posting lists ends with an
+// entry with trigram "\xff\xff\xff" and a delta list consisting a single zero.
+// In the γ-encoding, which cannot represent 0, 0 encodes as 16,
+// and all values v ≥ 16 encode as v+1.
+//
+// Encoding 1 is a bitmap, used for dense lists:
+//
+//	first file ID [v]
+//	bitmap size in bytes [v]
+//	bitmap
+//
+// Bit i of the bitmap, counting from the low-order bit of the first byte,
+// is set if the list contains the file ID first+i.
+//
+// Encoding 2 is a sequence of blocks of up to 128 deltas, used for lists
+// where it is close in size to the delta list, since blocks are faster
+// to decode. Each block has the form:
+//
+//	bit width w [1]
+//	deltas [w bits each]
+//
+// Each delta minus one is stored in w bits, counting from the low-order
+// bit of each byte, and the block is padded to a whole number of bytes.
+// Every block but the last holds 128 deltas; the file count in the posting
+// list index determines the size of the last. There is no terminating delta.
 //
 // The indexes enable efficient random access to the lists.
 //
@@ -55,6 +80,7 @@ package index
 //	trigram [3]
 //	file count [v]
 //	offset [v]
+//	encoding [1]
 //
 // The file count and offset are varint-encoded, breaking random
 // access to the posting list index. To restore that, any index
@@ -85,10 +111,124 @@ package index
 //	number of posting lists [8]
 //	offset of name index [8]
 //	offset of posting list index [8]
-//	"\ncsearch trlr 2\n"
+//	optional section entries [24]...
+//	number of optional sections [8]
+//	"\ncsearch trlr 4\n"
+//
+// Each optional section entry has the form:
+//
+//	name [8]
+//	offset [8]
+//	size [8]
+//
+// The name is an ASCII string padded to 8 bytes with NULs.
+// Readers ignore sections they do not understand, so new
+// sections can be added without changing the version.
+//
+// The "filemeta" section records metadata about each indexed file.
+// It is a sequence of fixed-size records, one per name, in fileid order.
+// Each record has the form:
+//
+//	size [8]
+//	modification time [8]
+//	content hash [32]
+//
+// The modification time is in nanoseconds since the Unix epoch,
+// or 0 if unknown. The content hash is the SHA-256 of the file content.
+// The record size is the section size divided by the number of names,
+// so that later versions can append fields to each record;
+// readers must use that size and ignore fields they do not understand.
+//
+// The "skips" section holds skip tables that let readers jump ahead
+// in long posting lists instead of decoding every delta. It has the form:
+//
+//	skip interval [8]
+//	number of skip tables [8]
+//	skip table directory
+//	skip tables
+//
+// The directory has one entry for each posting list with a skip table,
+// in trigram order:
+//
+//	trigram [8]
+//	offset of skip table [8]
+//
+// The offset is relative to the start of the first skip table.
+// The skip table for a list with count entries has count/interval entries.
+// Entry k (counting from 1) has the form:
+//
+//	file ID [8]
+//	bit offset [8]
+//
+// The file ID is that of the (k*interval)'th entry in the posting list,
+// and the bit offset is the position in the list's file IDs
+// (after the trigram) just past that entry. Bits are numbered
+// from the low-order bit of each byte. A reader can resume decoding
+// at that offset with the file ID as the previous ID.
+// Not every long list needs a skip table. Bitmap lists never have one,
+// and block lists have one only when the interval is a multiple of
+// the block size, so that every entry falls at the end of a block.
+//
+// The "rootopts" section records the options used to index each root,
+// for roots that have them. It is a sequence of entries in root order:
+//
+//	root [uvarint length, bytes]
+//	number of options [uvarint]
+//	options [uvarint length, bytes]...
+//
+// The options are opaque to the index package. Cindex stores the
+// command-line flags that affect how it indexes the root.
+//
+// The "partial" section lists the files that were only partially
+// indexed, because they exceeded the indexer's limits on file size,
+// line length or number of trigrams. It is a sequence of file IDs [8]
+// in increasing order. The posting lists for a partially indexed file
+// may omit trigrams that appear in the file, so readers must treat
+// the file as matching any query.
+//
+// The "encoding" section lists the files that were not encoded in
+// UTF-8 and were decoded to UTF-8 for indexing. It is a sequence of
+// entries in increasing file ID order:
+//
+//	file ID [8]
+//	encoding [8]
+//
+// The encodings are 1 for UTF-16LE, 2 for UTF-16BE and 3 for Latin-1.
+// The posting lists for such a file describe the decoded text,
+// so readers must decode the file the same way before searching it.
+//
+// The "archives" section records the size and modification time of
+// each archive whose members were indexed (see IndexWriter.Zip), so
+// that an unchanged archive can be reused without reading it. It is a
+// sequence of entries in increasing file ID order:
+//
+//	file ID of first member [8]
+//	size [8]
+//	modification time [8]
+//
+// The modification time is in nanoseconds since the Unix epoch.
 //
 // The code has never checked the index header, so version changes
 // must be made by modifying the trailer.
+//
+// Version 3
+//
+// The version 3 format is the same as version 4 except that:
+//
+//  - The header is "csearch index 3\n".
+//  - The trailer is "\ncsearch trlr 3\n".
+//  - Posting list index entries have no encoding field,
+//    and all posting lists are delta lists.
+//
+// Version 2
+//
+// The version 2 format is the same as version 3 except that:
+//
+//  - The header is "csearch index 2\n".
+//  - The trailer is "\ncsearch trlr 2\n".
+//  - There are no optional sections, and the trailer ends
+//    with the offset of the posting list index.
+//
 // Old 32-bit Version
 //
 // An older 32-bit format had the following differences:
@@ -147,6 +287,7 @@ package index
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"iter"
 	"log"
@@ -154,13 +295,18 @@ import (
 	"path/filepath"
 	"runtime"
 	"sort"
+	"strings"
 )
 
 const (
 	magicV1        = "csearch index 1\n"
 	magicV2        = "csearch index 2\n"
+	magicV3        = "csearch index 3\n"
+	magicV4        = "csearch index 4\n"
 	trailerMagicV1 = "\ncsearch trailr\n"
 	trailerMagicV2 = "\ncsearch trlr 2\n"
+	trailerMagicV3 = "\ncsearch trlr 3\n"
+	trailerMagicV4 = "\ncsearch trlr 4\n"
 
 	postBlockSize = 256 // posting index entries are packed into 256-byte blocks
 	nameGroupSize = 16  // names are prefix-compressed in groups of 16
@@ -183,14 +329,32 @@ type Index struct {
 	postIndex    int
 	numPost      int
 	numPostBlock int
+	sections     []section
+	metaData     int            // offset of file metadata records
+	metaSize     int            // size of each file metadata record; 0 if none
+	skipInterval int            // postings between skip table entries
+	numSkip      int            // number of skip tables
+	skipDir      int            // offset of skip table directory
+	skipData     int            // offset of skip tables
+	skipEnd      int            // end of skips section
+	partial      []int          // fileids of partially indexed files
+	encodings    []fileEncoding // encodings of files not in UTF-8
+	archives     []archiveMeta  // archives, by first member
 }
 
 func (ix *Index) PrintStats() {
 	fmt.Printf("%d path list (%d paths)\n", ix.nameData-ix.pathData, ix.numPath)
 	fmt.Printf("%d name list (%d names)\n", ix.postData-ix.nameData, ix.numName)
 	fmt.Printf("%d posting lists (%d trigrams)\n", ix.nameIndex-ix.postData, ix.numPost)
-	fmt.Printf("%d name index\n", ix.postIndex-ix.nameIndex)
+	end := ix.postIndex
+	for _, sec := range ix.sections {
+		end = min(end, sec.offset)
+	}
+	fmt.Printf("%d name index\n", end-ix.nameIndex)
 	fmt.Printf("%d posting index\n", ix.numPostBlock*postBlockSize)
+	for _, sec := range ix.sections {
+		fmt.Printf("%d %s section\n", sec.size, sec.name)
+	}
 }
 
 func Open(file string) *Index {
@@ -236,6 +400,56 @@ func Open(file string) *Index {
 		ix.nameIndex = ix.uint64(n + 6*8)
 		ix.postIndex = ix.uint64(n + 7*8)
 		ix.numPostBlock = (n - ix.postIndex) / postBlockSize
+
+	case trailerMagicV3, trailerMagicV4:
+		ix.version = 3
+		if magic == trailerMagicV4 {
+			ix.version = 4
+		}
+		n = len(mm.d) - len(trailerMagicV3) - 8
+		if n < 0 {
+			ix.corrupt()
+		}
+		nsec := ix.uint64(n)
+		if nsec > n/sectionEntrySize {
+			ix.corrupt()
+		}
+		n -= nsec * sectionEntrySize
+		for i := range nsec {
+			ix.sections = append(ix.sections, ix.sectionEntry(n+i*sectionEntrySize))
+		}
+		n -= 8 * 8
+		if n < 0 {
+			ix.corrupt()
+		}
+		ix.pathData = ix.uint64(n)
+		ix.numPath = ix.uint64(n + 1*8)
+		ix.nameData = ix.uint64(n + 2*8)
+		ix.numName = ix.uint64(n + 3*8)
+		ix.postData = ix.uint64(n + 4*8)
+		ix.numPost = ix.uint64(n + 5*8)
+		ix.nameIndex = ix.uint64(n + 6*8)
+		ix.postIndex = ix.uint64(n + 7*8)
+		ix.numPostBlock = (n - ix.postIndex) / postBlockSize
+		if sec, ok := ix.section(sectionFileMeta); ok && ix.numName > 0 {
+			ix.metaData = sec.offset
+			ix.metaSize = sec.size / ix.numName
+			if ix.metaSize < metaRecordSize || ix.metaSize*ix.numName != sec.size {
+				ix.corrupt()
+			}
+		}
+		if sec, ok := ix.section(sectionSkips); ok {
+			ix.initSkips(sec)
+		}
+		if sec, ok := ix.section(sectionPartial); ok {
+			ix.initPartial(sec)
+		}
+		if sec, ok := ix.section(sectionEncoding); ok {
+			ix.initEncodings(sec)
+		}
+		if sec, ok := ix.section(sectionArchives); ok {
+			ix.initArchives(sec)
+		}
 	}
 
 	return ix
@@ -299,7 +513,7 @@ func (ix *Index) NamesAt(min, max int) *PathReader {
 		limit += min % nameGroupSize
 	}
 	names := NewPathReader(ix.version, ix.slice(ix.nameData+off, ix.postData-(ix.nameData+off)), limit)
-	if ix.version == 2 {
+	if ix.version >= 2 {
 		for range min % nameGroupSize {
 			names.Next()
 		}
@@ -346,7 +560,13 @@ func (ix *Index) postIndexEntry(i int) (trigram uint32, count, offset int) {
 }
 
 func (ix *Index) findList(trigram uint32) (count, offset int) {
-	if ix.version == 2 {
+	count, offset, _ = ix.findListEnc(trigram)
+	return count, offset
+}
+
+// findListEnc is like findList but also returns the list's encoding.
+func (ix *Index) findListEnc(trigram uint32) (count, offset, enc int) {
+	if ix.version >= 2 {
 		return ix.findListV2(trigram)
 	}
 	// binary search
@@ -357,16 +577,16 @@ func (ix *Index) findList(trigram uint32) (count, offset int) {
 		return t >= trigram
 	})
 	if i >= ix.numPost {
-		return 0, 0
+		return 0, 0, 0
 	}
 	t, count, offset := ix.postIndexEntry(i)
 	if t != trigram {
-		return 0, 0
+		return 0, 0, 0
 	}
-	return count, offset
+	return count, offset, encGamma
 }
 
-func (ix *Index) findListV2(trigram uint32) (count, offset int) {
+func (ix *Index) findListV2(trigram uint32) (count, offset, enc int) {
 	// binary search to find first posting block too late for trigram
 	b := ix.slice(ix.postIndex, ix.numPostBlock*postBlockSize)
 	i := sort.Search(ix.numPostBlock, func(i int) bool {
@@ -375,53 +595,79 @@ func (ix *Index) findListV2(trigram uint32) (count, offset int) {
 		return t > trigram
 	})
 	if i == 0 {
-		return 0, 0
+		return 0, 0, 0
 	}
 
 	// walk block to find trigram
 	b = b[(i-1)*postBlockSize : i*postBlockSize]
 	for len(b) >= 3 {
-		t := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+		t, count, o, enc, n := ix.postBlockEntry(b)
 		if t == 0 {
 			break
 		}
-		count, n1 := binary.Uvarint(b[3:])
-		if n1 < 0 {
-			ix.corrupt()
+		offset += o
+		if t == trigram {
+			return count, offset, enc
 		}
-		o, n2 := binary.Uvarint(b[3+n1:])
-		if n2 < 0 {
+		b = b[n:]
+	}
+	return 0, 0, 0
+}
+
+// postBlockEntry parses the posting index entry at the start of b,
+// which is in a version 2 or later posting index block.
+// It returns the entry's fields and its length in bytes.
+// If b begins with block padding, postBlockEntry returns trigram 0.
+func (ix *Index) postBlockEntry(b []byte) (trigram uint32, count, offset, enc, n int) {
+	trigram = uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	if trigram == 0 {
+		return 0, 0, 0, 0, 0
+	}
+	c, n1 := binary.Uvarint(b[3:])
+	if n1 <= 0 {
+		ix.corrupt()
+	}
+	o, n2 := binary.Uvarint(b[3+n1:])
+	if n2 <= 0 {
+		ix.corrupt()
+	}
+	n = 3 + n1 + n2
+	if ix.version >= 4 {
+		if len(b) <= n || b[n] > encBlock {
 			ix.corrupt()
 		}
-		offset += int(o)
-		if t == trigram {
-			return int(count), offset
-		}
-		b = b[3+n1+n2:]
+		enc = int(b[n])
+		n++
 	}
-	return 0, 0
+	return trigram, int(c), int(o), enc, n
 }
 
 type postReader struct {
 	ix       *Index
-	count    int
+	count    int // postings left to read
+	total    int // postings in list
 	offset   int
 	fileid   int
 	restrict []int
+	data     []byte // delta data
 	delta    deltaReader
+	skips    []byte // skip table; nil if none
 }
 
 func (r *postReader) init(ix *Index, trigram uint32, restrict []int) {
-	count, offset := ix.findList(trigram)
+	count, offset, enc := ix.findListEnc(trigram)
 	if count == 0 {
 		return
 	}
 	r.ix = ix
 	r.count = count
+	r.total = count
 	r.offset = offset
 	r.fileid = -1
-	r.delta.init(r.ix, ix.slice(ix.postData+offset+3, -1))
+	r.data = ix.slice(ix.postData+offset+3, -1)
+	r.delta.initList(r.ix, r.data, enc, count)
 	r.restrict = restrict
+	r.skips = ix.findSkips(trigram, count)
 }
 
 func (r *postReader) max() int {
@@ -433,6 +679,15 @@ func (r *postReader) next() bool {
 		return false
 	}
 	for r.count > 0 {
+		if r.restrict != nil {
+			if len(r.restrict) == 0 {
+				break
+			}
+			r.skip(r.restrict[0])
+			if r.count == 0 {
+				break
+			}
+		}
 		r.count--
 		delta := r.delta.next()
 		if delta <= 0 {
@@ -452,11 +707,12 @@ func (r *postReader) next() bool {
 		return true
 	}
 	// list should end with terminating 0 delta
-	if r.delta.next() != 0 {
+	if r.count == 0 && r.delta.next() != 0 {
 		r.ix.corrupt()
 	}
 	r.delta.clearBits()
 	r.fileid = -1
+	r.ix = nil
 	return false
 }
 
@@ -520,15 +776,12 @@ func (ix *Index) postingAnd(list []int, trigram uint32, restrict []int) []int {
 	var r postReader
 	r.init(ix, trigram, restrict)
 	x := list[:0]
-	i := 0
-	for r.next() {
-		fileid := r.fileid
-		for i < len(list) && list[i] < fileid {
-			i++
+	for _, fileid := range list {
+		if !r.seek(fileid) {
+			break
 		}
-		if i < len(list) && list[i] == fileid {
+		if r.fileid == fileid {
 			x = append(x, fileid)
-			i++
 		}
 	}
 	return x
@@ -558,8 +811,14 @@ func (ix *Index) postingOr(list []int, trigram uint32, restrict []int) []int {
 	return x
 }
 
+// PostingQuery returns the fileids of the files that may match q,
+// in increasing order, including any partially indexed files.
 func (ix *Index) PostingQuery(q *Query) []int {
-	return ix.postingQuery(q, nil)
+	list := ix.postingQuery(q, nil)
+	if q.Op != QNone {
+		list = ix.addPartial(list)
+	}
+	return list
 }
 
 func (ix *Index) postingQuery(q *Query, restrict []int) (ret []int) {
@@ -577,22 +836,24 @@ func (ix *Index) postingQuery(q *Query, restrict []int) (ret []int) {
 		}
 		return list
 	case QAnd:
-		for _, t := range q.Trigram {
-			tri := uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2])
-			if list == nil {
-				list = ix.postingList(tri, restrict)
-			} else {
-				list = ix.postingAnd(list, tri, restrict)
-			}
-			if len(list) == 0 {
-				return nil
-			}
+		plan := ix.planAnd(q)
+		if ix.Verbose {
+			log.Printf("plan %s", plan)
 		}
-		for _, sub := range q.Sub {
-			if list == nil {
-				list = restrict
+		for _, step := range plan {
+			switch {
+			case step.skip:
+				continue
+			case step.sub != nil:
+				if list == nil {
+					list = restrict
+				}
+				list = ix.postingQuery(step.sub, list)
+			case list == nil:
+				list = ix.postingList(step.tri, restrict)
+			default:
+				list = ix.postingAnd(list, step.tri, restrict)
 			}
-			list = ix.postingQuery(sub, list)
 			if len(list) == 0 {
 				return nil
 			}
@@ -614,6 +875,99 @@ func (ix *Index) postingQuery(q *Query, restrict []int) (ret []int) {
 	return list
 }
 
+// A planStep is one step in the evaluation of a QAnd query:
+// intersecting with a trigram's posting list or a subquery's result.
+type planStep struct {
+	tri  uint32 // trigram, if sub is nil
+	sub  *Query
+	cost int  // estimated number of matching files
+	skip bool // too common to be worth evaluating
+}
+
+// A plan is the order in which to evaluate the steps of a QAnd query.
+type plan []planStep
+
+func (p plan) String() string {
+	var b strings.Builder
+	for i, step := range p {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		if step.skip {
+			b.WriteString("-")
+		}
+		if step.sub != nil {
+			fmt.Fprintf(&b, "%s~%d", step.sub, step.cost)
+		} else {
+			fmt.Fprintf(&b, "%q:%d", string([]byte{byte(step.tri >> 16), byte(step.tri >> 8), byte(step.tri)}), step.cost)
+		}
+	}
+	return b.String()
+}
+
+// planAnd returns the plan for evaluating the QAnd query q.
+// It orders the trigrams and subqueries by estimated cost,
+// so that the most selective are evaluated first, and it skips
+// trigrams that appear in nearly every file, since intersecting
+// with them costs much and narrows the result very little.
+func (ix *Index) planAnd(q *Query) plan {
+	var p plan
+	for _, t := range q.Trigram {
+		tri := uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2])
+		count, _ := ix.findList(tri)
+		p = append(p, planStep{tri: tri, cost: count})
+	}
+	for _, sub := range q.Sub {
+		p = append(p, planStep{sub: sub, cost: ix.cost(sub)})
+	}
+	sort.SliceStable(p, func(i, j int) bool { return p[i].cost < p[j].cost })
+	for i := 1; i < len(p); i++ {
+		if p[i].sub == nil && ix.common(p[i].cost) {
+			p[i].skip = true
+		}
+	}
+	return p
+}
+
+// A trigram is common if it is missing from fewer than
+// 1/commonDivisor of the files in the index.
+const commonDivisor = 10
+
+// common reports whether a trigram appearing in count files
+// is too common to be worth intersecting with.
+func (ix *Index) common(count int) bool {
+	return count > ix.numName-ix.numName/commonDivisor
+}
+
+// cost returns an estimate of the number of files matching q.
+func (ix *Index) cost(q *Query) int {
+	switch q.Op {
+	case QAll:
+		return ix.numName
+	case QAnd:
+		n := ix.numName
+		for _, t := range q.Trigram {
+			count, _ := ix.findList(uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2]))
+			n = min(n, count)
+		}
+		for _, sub := range q.Sub {
+			n = min(n, ix.cost(sub))
+		}
+		return n
+	case QOr:
+		n := 0
+		for _, t := range q.Trigram {
+			count, _ := ix.findList(uint32(t[0])<<16 | uint32(t[1])<<8 | uint32(t[2]))
+			n += count
+		}
+		for _, sub := range q.Sub {
+			n += ix.cost(sub)
+		}
+		return min(n, ix.numName)
+	}
+	return 0
+}
+
 func mergeOr(l1, l2 []int) []int {
 	var l []int
 	i := 0
@@ -659,20 +1013,71 @@ func mmap(file string) mmapData {
 	return mmapFile(f)
 }
 
-// TODO look in parent directories for index
-// TODO cindex -init
+// close unmaps the data and closes the file.
+func (m *mmapData) close() error {
+	var err error
+	if m.d != nil {
+		err = munmap(m.d)
+		m.d = nil
+	}
+	return errors.Join(err, m.f.Close())
+}
+
+// close closes the index file, which must not be used again.
+func (ix *Index) close() error {
+	return ix.data.close()
+}
 
 // File returns the name of the index file to use.
-// It is either $CSEARCHINDEX or $HOME/.csearchindex.
+// It is the first of the files returned by Files.
 func File() string {
-	f := os.Getenv("CSEARCHINDEX")
-	if f != "" {
-		return f
+	return Files()[0]
+}
+
+// Files returns the names of the index files to search.
+// They are the files listed in $CSEARCHINDEX, separated by
+// filepath.ListSeparator. If $CSEARCHINDEX is unset or empty,
+// Files looks for a project index named .csearchindex in the current
+// directory and its parents (see cindex -init), and failing that
+// uses $HOME/.csearchindex.
+func Files() []string {
+	var files []string
+	for _, f := range filepath.SplitList(os.Getenv("CSEARCHINDEX")) {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	if len(files) > 0 {
+		return files
+	}
+	if f := projectFile(); f != "" {
+		return []string{f}
 	}
 	var home string
 	home = os.Getenv("HOME")
 	if runtime.GOOS == "windows" && home == "" {
 		home = os.Getenv("USERPROFILE")
 	}
-	return filepath.Clean(home + "/.csearchindex")
+	return []string{filepath.Clean(home + "/.csearchindex")}
+}
+
+// projectFile returns the name of the .csearchindex file
+// in the nearest enclosing directory that has one,
+// or "" if there is none.
+func projectFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		f := filepath.Join(dir, ".csearchindex")
+		if info, err := os.Stat(f); err == nil && info.Mode().IsRegular() {
+			return f
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
 }
@@ -5,7 +5,9 @@
 package index
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
 	"testing"
 )
@@ -51,3 +53,81 @@ func TestTrivialPosting(t *testing.T) {
 		t.Errorf("PostingList(Goo|Sea) = %v, want [1 2 3]", l)
 	}
 }
+
+func TestFiles(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a", ".csearchindex"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CSEARCHINDEX", "")
+	t.Setenv("HOME", dir)
+	if f := Files(); !slices.Equal(f, []string{filepath.Join(dir, "a", ".csearchindex")}) {
+		t.Errorf("Files() = %v, want project index", f)
+	}
+
+	list := "x" + string(filepath.ListSeparator) + string(filepath.ListSeparator) + "y"
+	t.Setenv("CSEARCHINDEX", list)
+	if f := Files(); !slices.Equal(f, []string{"x", "y"}) {
+		t.Errorf("Files() with CSEARCHINDEX=%q = %v, want [x y]", list, f)
+	}
+	if f := File(); f != "x" {
+		t.Errorf("File() = %q, want x", f)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CSEARCHINDEX", "")
+	if f := Files(); !slices.Equal(f, []string{filepath.Join(dir, ".csearchindex")}) {
+		t.Errorf("Files() = %v, want $HOME/.csearchindex", f)
+	}
+}
+
+func TestPlanAnd(t *testing.T) {
+	files := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("/a/file%02d", i)] = "the usual text"
+	}
+	files["/a/file03"] += " with a rare word"
+	files["/a/file07"] += " with a rarer word"
+	files["/a/file09"] += " with a word"
+	f, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f.Name())
+	buildIndex(f.Name(), nil, files)
+	ix := Open(f.Name())
+
+	q := &Query{
+		Op:      QAnd,
+		Trigram: []string{"the", "wor", "rar"},
+		Sub:     []*Query{{Op: QOr, Trigram: []string{"are", "rer"}}},
+	}
+	p := ix.planAnd(q)
+	if s := p.String(); s != `"rar":2 "wor":3 ("are"|"rer")~3 -"the":20` {
+		t.Errorf("plan = %s", s)
+	}
+	if l := ix.PostingQuery(q); !slices.Equal(l, []int{3, 7}) {
+		t.Errorf("PostingQuery = %v, want [3 7]", l)
+	}
+
+	// A common trigram is still used if it is all there is.
+	q = &Query{Op: QAnd, Trigram: []string{"the"}}
+	if s := ix.planAnd(q).String(); s != `"the":20` {
+		t.Errorf("plan = %s", s)
+	}
+}
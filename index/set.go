@@ -0,0 +1,298 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+// Sharded indexes.
+//
+// A large index can be split into several index files, called shards,
+// each indexing a disjoint set of roots. A manifest file lists the shards:
+//
+//	csearch manifest
+//	# comment
+//	shard1.idx
+//	/abs/path/shard2.idx
+//
+// The first line identifies the file as a manifest. Each following line
+// names one shard; relative names are interpreted relative to the directory
+// containing the manifest. Blank lines and lines beginning with # are ignored.
+//
+// A Set presents the shards as a single index. Fileids are assigned
+// consecutively across shards in manifest order: the files in the first
+// shard come first, then the files in the second shard, and so on.
+//
+// A Set can also combine several index files, each of which may be a
+// manifest, as in a search path. Unlike shards in a single manifest,
+// the files may index overlapping roots. In that case the earlier file
+// wins: files in a later index that fall under a root of an earlier
+// index are hidden.
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"iter"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const manifestMagic = "csearch manifest\n"
+
+// A Set is a collection of index shards searched as a single index.
+type Set struct {
+	Verbose bool
+	shards  []*Index
+	base    []int    // base[i] is the fileid in s of fileid 0 in shards[i]
+	shadow  [][]Path // shadow[i] lists the roots hiding files in shards[i]
+	numName int
+}
+
+// IsManifest reports whether file is a shard manifest
+// rather than an index.
+func IsManifest(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, len(manifestMagic))
+	_, err = io.ReadFull(f, buf)
+	return err == nil && string(buf) == manifestMagic
+}
+
+// OpenSet opens the index set described by file.
+// If file is a shard manifest, the set contains the shards it lists.
+// Otherwise file must be an index, and the set contains just that index.
+func OpenSet(file string) *Set {
+	return OpenSetList([]string{file})
+}
+
+// OpenSetList opens a set containing the indexes named by files,
+// each of which may be a shard manifest or an index.
+// If the files index overlapping roots, earlier files take precedence.
+func OpenSetList(files []string) *Set {
+	s := new(Set)
+	var earlier []Path // roots of earlier files
+	for _, file := range files {
+		shards := openShards(file)
+		var roots []Path
+		for _, ix := range shards {
+			s.shards = append(s.shards, ix)
+			s.base = append(s.base, s.numName)
+			s.shadow = append(s.shadow, earlier)
+			s.numName += ix.numName
+			roots = slices.AppendSeq(roots, ix.Roots().All())
+		}
+		earlier = topRoots(append(slices.Clone(earlier), roots...))
+	}
+	return s
+}
+
+// openShards returns the indexes listed in the manifest file,
+// or just the index file if file is not a manifest.
+func openShards(file string) []*Index {
+	if !IsManifest(file) {
+		return []*Index{Open(file)}
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var shards []*Index
+	s := bufio.NewScanner(bytes.NewReader(data[len(manifestMagic):]))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(file), line)
+		}
+		shards = append(shards, Open(line))
+	}
+	if err := s.Err(); err != nil {
+		log.Fatalf("%s: %v", file, err)
+	}
+
+	// Check that the shards index disjoint roots.
+	type shardRoot struct {
+		root  Path
+		shard *Index
+	}
+	var roots []shardRoot
+	for _, ix := range shards {
+		for root := range ix.Roots().All() {
+			roots = append(roots, shardRoot{root, ix})
+		}
+	}
+	slices.SortFunc(roots, func(x, y shardRoot) int { return x.root.Compare(y.root) })
+	var top shardRoot // last root not contained in an earlier one
+	for _, r := range roots {
+		if top.shard != nil && r.root.HasPathPrefix(top.root) {
+			if r.shard != top.shard {
+				log.Fatalf("%s: shards %s and %s both index %s", file, top.shard.name, r.shard.name, r.root)
+			}
+			continue
+		}
+		top = r
+	}
+	return shards
+}
+
+// topRoots sorts roots and removes the ones contained in other roots.
+func topRoots(roots []Path) []Path {
+	slices.SortFunc(roots, Path.Compare)
+	var top []Path
+	for _, root := range roots {
+		if len(top) > 0 && root.HasPathPrefix(top[len(top)-1]) {
+			continue
+		}
+		top = append(top, root)
+	}
+	return top
+}
+
+// hidden reports whether name is under one of the roots,
+// which must be sorted with none containing another.
+func hidden(name Path, roots []Path) bool {
+	// Only the last root ordered before name can contain it.
+	i := sort.Search(len(roots), func(i int) bool { return roots[i].Compare(name) > 0 })
+	if i == 0 {
+		return false
+	}
+	root := roots[i-1]
+	return name.HasPathPrefix(root) || strings.HasPrefix(name.String(), root.String()+"\x01")
+}
+
+// Shards returns the indexes in the set.
+func (s *Set) Shards() []*Index {
+	return s.shards
+}
+
+// NumFiles returns the number of files in the set.
+func (s *Set) NumFiles() int {
+	return s.numName
+}
+
+// Roots returns the roots of all the shards, in sorted order.
+// Roots contained in other roots are omitted.
+func (s *Set) Roots() iter.Seq[Path] {
+	var roots []Path
+	for _, ix := range s.shards {
+		roots = slices.AppendSeq(roots, ix.Roots().All())
+	}
+	return slices.Values(topRoots(roots))
+}
+
+// shard returns the index of the shard holding fileid.
+func (s *Set) shard(fileid int) int {
+	return sort.Search(len(s.base), func(i int) bool { return s.base[i] > fileid }) - 1
+}
+
+// Name returns the name corresponding to the given fileid.
+func (s *Set) Name(fileid int) Path {
+	i := s.shard(fileid)
+	if i < 0 {
+		return Path{}
+	}
+	return s.shards[i].Name(fileid - s.base[i])
+}
+
+// PostingQuery returns the fileids of the files that may match q,
+// in name order. When the set is a single index, or its shards index
+// roots in manifest order, that is also increasing fileid order.
+func (s *Set) PostingQuery(q *Query) []int {
+	type result struct {
+		ids   []int
+		names []Path
+	}
+	var results []result
+	n := 0
+	for i, ix := range s.shards {
+		ix.Verbose = s.Verbose
+		var r result
+		for _, fileid := range ix.PostingQuery(q) {
+			name := ix.Name(fileid)
+			if hidden(name, s.shadow[i]) {
+				continue
+			}
+			r.ids = append(r.ids, s.base[i]+fileid)
+			r.names = append(r.names, name)
+		}
+		results = append(results, r)
+		n += len(r.ids)
+	}
+
+	// Merge the per-shard lists in name order.
+	list := make([]int, 0, n)
+	for len(list) < n {
+		min := -1
+		for i, r := range results {
+			if len(r.ids) > 0 && (min < 0 || r.names[0].Compare(results[min].names[0]) < 0) {
+				min = i
+			}
+		}
+		r := &results[min]
+		list = append(list, r.ids[0])
+		r.ids, r.names = r.ids[1:], r.names[1:]
+	}
+	return list
+}
+
+// PostingQuerySeq returns the fileids of the files that may match q,
+// in the same order as PostingQuery, but reading the shards' posting
+// lists only as needed to yield each one.
+func (s *Set) PostingQuerySeq(q *Query) iter.Seq[int] {
+	if len(s.shards) == 1 {
+		// No shadowing or merging to do.
+		s.shards[0].Verbose = s.Verbose
+		return s.shards[0].PostingQuerySeq(q)
+	}
+	return func(yield func(int) bool) {
+		// A stream is the remaining results from one shard.
+		type stream struct {
+			shard int
+			it    postIter
+			id    int // current fileid in shard, or -1 at end
+			name  Path
+		}
+		advance := func(st *stream, id int) {
+			ix := s.shards[st.shard]
+			for st.id = st.it.seek(id); st.id >= 0; st.id = st.it.seek(st.id + 1) {
+				if st.name = ix.Name(st.id); !hidden(st.name, s.shadow[st.shard]) {
+					return
+				}
+			}
+		}
+
+		var streams []*stream
+		for i, ix := range s.shards {
+			ix.Verbose = s.Verbose
+			st := &stream{shard: i, it: ix.candidates(q)}
+			if advance(st, 0); st.id >= 0 {
+				streams = append(streams, st)
+			}
+		}
+		for len(streams) > 0 {
+			min := 0
+			for i, st := range streams {
+				if st.name.Compare(streams[min].name) < 0 {
+					min = i
+				}
+			}
+			st := streams[min]
+			if !yield(s.base[st.shard] + st.id) {
+				return
+			}
+			if advance(st, st.id+1); st.id < 0 {
+				streams = slices.Delete(streams, min, min+1)
+			}
+		}
+	}
+}
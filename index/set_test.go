@@ -0,0 +1,106 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	dir := t.TempDir()
+	buildIndex(filepath.Join(dir, "shard1"), []string{"/a", "/b"}, map[string]string{
+		"/a/x":  "hello world",
+		"/a/y":  "goodbye world",
+		"/b/xx": "now is the time",
+	})
+	buildIndex(filepath.Join(dir, "shard2"), []string{"/c"}, map[string]string{
+		"/c/ab": "give me all the potatoes",
+		"/c/de": "or give me death now",
+	})
+	manifest := filepath.Join(dir, "manifest")
+	if err := os.WriteFile(manifest, []byte("csearch manifest\n# test shards\nshard1\n\n"+filepath.Join(dir, "shard2")+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if IsManifest(filepath.Join(dir, "shard1")) || !IsManifest(manifest) {
+		t.Fatalf("IsManifest is wrong")
+	}
+	s := OpenSet(manifest)
+	if n := s.NumFiles(); n != 5 {
+		t.Errorf("NumFiles() = %d, want 5", n)
+	}
+	for i, name := range []string{"/a/x", "/a/y", "/b/xx", "/c/ab", "/c/de"} {
+		if n := s.Name(i).String(); n != name {
+			t.Errorf("Name(%d) = %s, want %s", i, n, name)
+		}
+	}
+	if roots := slices.Collect(s.Roots()); !slices.Equal(roots, []Path{MakePath("/a"), MakePath("/b"), MakePath("/c")}) {
+		t.Errorf("Roots() = %v, want [/a /b /c]", roots)
+	}
+
+	q := &Query{Op: QAnd, Trigram: []string{"now"}}
+	if l := s.PostingQuery(q); !slices.Equal(l, []int{2, 4}) {
+		t.Errorf("PostingQuery(now) = %v, want [2 4]", l)
+	}
+	q = &Query{Op: QOr, Trigram: []string{"wor", "pot"}}
+	if l := s.PostingQuery(q); !slices.Equal(l, []int{0, 1, 3}) {
+		t.Errorf("PostingQuery(wor|pot) = %v, want [0 1 3]", l)
+	}
+	if l := slices.Collect(s.PostingQuerySeq(q)); !slices.Equal(l, []int{0, 1, 3}) {
+		t.Errorf("PostingQuerySeq(wor|pot) = %v, want [0 1 3]", l)
+	}
+
+	// A plain index is a set of one shard.
+	s = OpenSet(filepath.Join(dir, "shard2"))
+	if n := s.NumFiles(); n != 2 || s.Name(1).String() != "/c/de" {
+		t.Errorf("OpenSet(shard2) has %d files, Name(1) = %s", n, s.Name(1))
+	}
+}
+
+func TestSetList(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	buildIndex(first, []string{"/c", "/d/x"}, map[string]string{
+		"/c/ab":  "now is the time",
+		"/d/x/y": "now you see it",
+	})
+	second := filepath.Join(dir, "second")
+	buildIndex(second, []string{"/b", "/c", "/d"}, map[string]string{
+		"/b/ab":  "now and then",
+		"/c/ab":  "an older copy, now stale",
+		"/c/zz":  "no longer there, now",
+		"/d/w":   "now here",
+		"/d/x/y": "now you don't",
+	})
+
+	s := OpenSetList([]string{first, second})
+	if n := s.NumFiles(); n != 7 {
+		t.Errorf("NumFiles() = %d, want 7", n)
+	}
+	if roots := slices.Collect(s.Roots()); !slices.Equal(roots, []Path{MakePath("/b"), MakePath("/c"), MakePath("/d")}) {
+		t.Errorf("Roots() = %v, want [/b /c /d]", roots)
+	}
+
+	// Files under /c and /d/x come from the first index,
+	// and the results are in name order.
+	q := &Query{Op: QAnd, Trigram: []string{"now"}}
+	var names []string
+	for _, fileid := range s.PostingQuery(q) {
+		names = append(names, s.Name(fileid).String())
+	}
+	want := []string{"/b/ab", "/c/ab", "/d/w", "/d/x/y"}
+	if !slices.Equal(names, want) {
+		t.Errorf("PostingQuery(now) names = %v, want %v", names, want)
+	}
+	if l, seq := s.PostingQuery(q), slices.Collect(s.PostingQuerySeq(q)); !slices.Equal(seq, l) {
+		t.Errorf("PostingQuerySeq(now) = %v, want %v", seq, l)
+	}
+	if l := s.PostingQuery(&Query{Op: QAnd, Trigram: []string{"ale"}}); len(l) != 0 {
+		t.Errorf("PostingQuery(ale) = %v, want none from shadowed file", l)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+)
+
+// Skip tables for long posting lists.
+// See read.go for details of the on-disk format.
+
+const (
+	sectionSkips   = "skips"
+	skipEntrySize  = 8 + 8
+	skipDirEntSize = 8 + 8
+)
+
+// skipInterval is the number of postings between skip table entries
+// in the indexes that IndexWriter and Merge write.
+// Lists with fewer postings have no skip table.
+// It is a variable so that tests can change it.
+var skipInterval = 256
+
+// initSkips records the location of the skip tables in sec.
+func (ix *Index) initSkips(sec section) {
+	if sec.size < 16 {
+		ix.corrupt()
+	}
+	ix.skipInterval = ix.uint64(sec.offset)
+	ix.numSkip = ix.uint64(sec.offset + 8)
+	ix.skipDir = sec.offset + 16
+	ix.skipData = ix.skipDir + ix.numSkip*skipDirEntSize
+	ix.skipEnd = sec.offset + sec.size
+	if ix.skipInterval <= 0 || ix.numSkip < 0 || ix.numSkip > sec.size/skipDirEntSize || ix.skipData > ix.skipEnd {
+		ix.corrupt()
+	}
+}
+
+// findSkips returns the skip table for the posting list
+// for trigram, which has count entries.
+// It returns nil if the list has no skip table.
+func (ix *Index) findSkips(trigram uint32, count int) []byte {
+	if ix.numSkip == 0 || count < ix.skipInterval {
+		return nil
+	}
+	dir := ix.slice(ix.skipDir, ix.numSkip*skipDirEntSize)
+	i := sort.Search(ix.numSkip, func(i int) bool {
+		return binary.BigEndian.Uint64(dir[i*skipDirEntSize:]) >= uint64(trigram)
+	})
+	if i >= ix.numSkip || binary.BigEndian.Uint64(dir[i*skipDirEntSize:]) != uint64(trigram) {
+		return nil
+	}
+	off := ix.skipData + ix.uint64(ix.skipDir+i*skipDirEntSize+8)
+	n := count / ix.skipInterval * skipEntrySize
+	if off+n > ix.skipEnd {
+		ix.corrupt()
+	}
+	return ix.slice(off, n)
+}
+
+// skipEntry returns the k'th entry (counting from 1) in the skip table:
+// the fileid of the (k*interval)'th posting in the list
+// and the bit offset in the list's delta data just after it.
+func (ix *Index) skipEntry(skips []byte, k int) (fileid, bit int) {
+	e := skips[(k-1)*skipEntrySize:]
+	fileid = int(binary.BigEndian.Uint64(e))
+	bit = int(binary.BigEndian.Uint64(e[8:]))
+	if fileid < 0 || bit < 0 {
+		ix.corrupt()
+	}
+	return fileid, bit
+}
+
+// skip advances r using its skip table, if any, to the last
+// position from which reading forward can still find target.
+// Only a position past the current one is used.
+func (r *postReader) skip(target int) {
+	if r.delta.enc == encBitmap {
+		r.count -= r.delta.skipBitmap(target)
+		return
+	}
+	if r.skips == nil {
+		return
+	}
+	n := len(r.skips) / skipEntrySize
+	done := (r.total - r.count) / r.ix.skipInterval // entries at or before the current position
+
+	// Find the last entry k with fileid < target by galloping
+	// forward from the current position and then binary searching.
+	lo, hi := done, done+1
+	for step := 1; hi <= n; step *= 2 {
+		if id, _ := r.ix.skipEntry(r.skips, hi); id >= target {
+			break
+		}
+		lo, hi = hi, hi+step
+	}
+	hi = min(hi, n+1)
+	for hi-lo > 1 {
+		m := int(uint(lo+hi) >> 1)
+		if id, _ := r.ix.skipEntry(r.skips, m); id < target {
+			lo = m
+		} else {
+			hi = m
+		}
+	}
+	if lo == done {
+		return
+	}
+
+	fileid, bit := r.ix.skipEntry(r.skips, lo)
+	if fileid <= r.fileid || bit/8 >= len(r.data) {
+		r.ix.corrupt()
+	}
+	r.fileid = fileid
+	r.count = r.total - lo*r.ix.skipInterval
+	r.delta.seekList(r.data, bit, r.count)
+}
+
+// seek advances r to the first fileid >= target,
+// reporting whether there is one.
+func (r *postReader) seek(target int) bool {
+	if r.ix == nil {
+		return false
+	}
+	if r.fileid >= target {
+		return true
+	}
+	r.skip(target)
+	for r.next() {
+		if r.fileid >= target {
+			return true
+		}
+	}
+	return false
+}
+
+// A skipWriter accumulates the skip tables for the posting lists
+// written by a postDataWriter.
+type skipWriter struct {
+	interval int
+	table    []byte  // entries for the current list
+	dir      []byte  // directory entries
+	data     *Buffer // skip tables; nil until the first is written
+	size     int     // bytes written to data
+}
+
+// add records that the posting for fileid ended at the given bit offset.
+func (s *skipWriter) add(fileid, bit int) {
+	s.table = binary.BigEndian.AppendUint64(s.table, uint64(fileid))
+	s.table = binary.BigEndian.AppendUint64(s.table, uint64(bit))
+}
+
+// endList writes the skip table, if any, for the list for trigram t.
+func (s *skipWriter) endList(t uint32) {
+	if len(s.table) == 0 {
+		return
+	}
+	if s.data == nil {
+		var err error
+		if s.data, err = createBuffer(""); err != nil {
+			s.data = &Buffer{err: err} // reported by writeSection
+		}
+	}
+	s.dir = binary.BigEndian.AppendUint64(s.dir, uint64(t))
+	s.dir = binary.BigEndian.AppendUint64(s.dir, uint64(s.size))
+	s.data.Write(s.table)
+	s.size += len(s.table)
+	s.table = s.table[:0]
+}
+
+// writeSection writes the skip tables section to out,
+// reporting false if there are no skip tables to write.
+func (s *skipWriter) writeSection(out *Buffer) (section, bool) {
+	if s == nil || s.data == nil {
+		return section{}, false
+	}
+	start := out.Offset()
+	out.WriteUint(s.interval)
+	out.WriteUint(len(s.dir) / skipDirEntSize)
+	out.Write(s.dir)
+	copyFile(out, s.data)
+	os.Remove(s.data.name)
+	return section{sectionSkips, start, out.Offset() - start}, true
+}
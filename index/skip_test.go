@@ -0,0 +1,125 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"testing"
+)
+
+func skipFiles(prefix string, n int) map[string]string {
+	files := make(map[string]string)
+	for i := range n {
+		s := "common text"
+		if i%3 == 0 {
+			s += " fizz"
+		}
+		if i%5 == 0 {
+			s += " buzz"
+		}
+		if i%37 == 0 {
+			s += " rare"
+		}
+		files[fmt.Sprintf("%s/file%04d", prefix, i)] = s
+	}
+	return files
+}
+
+func TestSkips(t *testing.T) {
+	oldVersion, oldInterval := writeVersion, skipInterval
+	defer func() {
+		writeVersion, skipInterval = oldVersion, oldInterval
+	}()
+
+	// Version 3 lists are all γ-coded. In version 4,
+	// the lists with skip tables are block-coded.
+	t.Run("V3", func(t *testing.T) {
+		writeVersion = 3
+		testSkips(t, 4, 200)
+	})
+	t.Run("V4", func(t *testing.T) {
+		writeVersion = 4
+		testSkips(t, blockLen, 2000)
+	})
+}
+
+func testSkips(t *testing.T, interval, n int) {
+	files := skipFiles("/a", n)
+	f1, _ := os.CreateTemp("", "index-test")
+	f2, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f1.Name())
+	defer os.Remove(f2.Name())
+	plain, skipped := f1.Name(), f2.Name()
+
+	skipInterval = 1 << 30
+	buildIndex(plain, []string{"/a"}, files)
+	skipInterval = interval
+	buildIndex(skipped, []string{"/a"}, files)
+
+	ix1 := Open(plain)
+	ix2 := Open(skipped)
+	if ix1.numSkip != 0 {
+		t.Fatalf("plain index has %d skip tables", ix1.numSkip)
+	}
+	if ix2.numSkip == 0 {
+		t.Fatalf("index has no skip tables")
+	}
+	if err := ix2.Check(); err != nil {
+		t.Fatal(err)
+	}
+
+	queries := []*Query{
+		{Op: QAnd, Trigram: []string{"rar", "com"}},
+		{Op: QAnd, Trigram: []string{"fiz", "buz"}},
+		{Op: QAnd, Trigram: []string{"com", "tex", "buz"}},
+		{Op: QAnd, Trigram: []string{"fiz"}, Sub: []*Query{{Op: QOr, Trigram: []string{"rar", "buz"}}}},
+	}
+	for _, q := range queries {
+		want := ix1.PostingQuery(q)
+		if have := ix2.PostingQuery(q); !slices.Equal(have, want) {
+			t.Errorf("PostingQuery(%s) = %v, want %v", q, have, want)
+		}
+	}
+
+	// Intersect directly, with and without a restriction.
+	list := []int{0, 1, 2, 3, 15, 16, 74, 75, 110, 111, 197, 199, 1500, 1998}
+	restrict := []int{3, 15, 75, 111, 199, 1500}
+	for _, s := range []string{"fiz", "buz", "com", "rar"} {
+		want := ix1.postingAnd(slices.Clone(list), tri(s), nil)
+		if have := ix2.postingAnd(slices.Clone(list), tri(s), nil); !slices.Equal(have, want) {
+			t.Errorf("postingAnd(%q) = %v, want %v", s, have, want)
+		}
+		want = ix1.postingList(tri(s), restrict)
+		if have := ix2.postingList(tri(s), restrict); !slices.Equal(have, want) {
+			t.Errorf("postingList(%q, restrict) = %v, want %v", s, have, want)
+		}
+	}
+
+	// Merge writes the same skip tables as IndexWriter.
+	f3, _ := os.CreateTemp("", "index-test")
+	f4, _ := os.CreateTemp("", "index-test")
+	f5, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f3.Name())
+	defer os.Remove(f4.Name())
+	defer os.Remove(f5.Name())
+	more := skipFiles("/b", 100)
+	buildIndex(f3.Name(), []string{"/b"}, more)
+	Merge(f4.Name(), skipped, f3.Name())
+	for name, data := range files {
+		more[name] = data
+	}
+	buildIndex(f5.Name(), []string{"/a", "/b"}, more)
+	data4, _ := os.ReadFile(f4.Name())
+	data5, _ := os.ReadFile(f5.Name())
+	if !bytes.Equal(data4, data5) {
+		t.Errorf("merged index differs from direct build")
+	}
+	if err := Open(f4.Name()).Check(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"iter"
+	"log"
+)
+
+// Streaming posting queries.
+//
+// PostingQuerySeq evaluates a query lazily, as a tree of postIters
+// mirroring the query. Each postIter is a sorted stream of file IDs
+// that can seek forward, so AND nodes can leapfrog from one input to
+// the next, using the skip tables and bitmaps of long posting lists,
+// and OR nodes can merge their inputs, without ever materializing
+// a posting list.
+
+// A postIter is a sorted stream of file IDs.
+type postIter interface {
+	// seek returns the first file ID ≥ id in the stream,
+	// or -1 if there is none. Successive calls must not
+	// decrease id.
+	seek(id int) int
+}
+
+// PostingQuerySeq returns the file IDs of the files that may match q,
+// in increasing order, like PostingQuery. Unlike PostingQuery,
+// it reads the posting lists only as needed to yield each file ID,
+// so a caller can start work on the first files right away
+// and can stop early without reading the rest.
+func (ix *Index) PostingQuerySeq(q *Query) iter.Seq[int] {
+	return func(yield func(int) bool) {
+		it := ix.candidates(q)
+		for id := it.seek(0); id >= 0; id = it.seek(id + 1) {
+			if !yield(id) {
+				return
+			}
+		}
+	}
+}
+
+// candidates returns a postIter for the file IDs that may match q:
+// those matching q and the partially indexed files.
+func (ix *Index) candidates(q *Query) postIter {
+	it := ix.queryIter(q)
+	if len(ix.partial) == 0 || q.Op == QNone {
+		return it
+	}
+	partial := sliceIter(ix.partial)
+	return newOrIter([]postIter{it, &partial})
+}
+
+// queryIter returns a postIter for the file IDs matching q.
+func (ix *Index) queryIter(q *Query) postIter {
+	switch q.Op {
+	case QAll:
+		return allIter(ix.numName)
+	case QAnd:
+		plan := ix.planAnd(q)
+		if ix.Verbose {
+			log.Printf("plan %s", plan)
+		}
+		it := &andIter{}
+		for _, step := range plan {
+			switch {
+			case step.skip:
+				continue
+			case step.sub != nil:
+				it.subs = append(it.subs, ix.queryIter(step.sub))
+			default:
+				it.subs = append(it.subs, ix.listIter(step.tri))
+			}
+		}
+		if len(it.subs) == 0 {
+			return allIter(ix.numName)
+		}
+		return it
+	case QOr:
+		var subs []postIter
+		for _, t := range q.Trigram {
+			subs = append(subs, ix.listIter(uint32(t[0])<<16|uint32(t[1])<<8|uint32(t[2])))
+		}
+		for _, sub := range q.Sub {
+			subs = append(subs, ix.queryIter(sub))
+		}
+		return newOrIter(subs)
+	}
+	return noneIter{}
+}
+
+// listIter returns a postIter for the posting list for trigram.
+func (ix *Index) listIter(trigram uint32) postIter {
+	it := new(listIter)
+	it.r.init(ix, trigram, nil)
+	return it
+}
+
+// A listIter is a postIter reading a single posting list.
+type listIter struct {
+	r postReader
+}
+
+func (it *listIter) seek(id int) int {
+	if !it.r.seek(id) {
+		return -1
+	}
+	return it.r.fileid
+}
+
+// An allIter is a postIter for all the file IDs in [0, n).
+type allIter int
+
+func (n allIter) seek(id int) int {
+	if id >= int(n) {
+		return -1
+	}
+	return id
+}
+
+// A noneIter is an empty postIter.
+type noneIter struct{}
+
+func (noneIter) seek(int) int { return -1 }
+
+// An andIter is a postIter for the intersection of its inputs,
+// which should be ordered from most to least selective.
+type andIter struct {
+	subs []postIter
+}
+
+func (it *andIter) seek(id int) int {
+	// Leapfrog: advance each input to the current candidate,
+	// moving the candidate forward as needed, until a full pass
+	// over the inputs leaves it unchanged.
+	for {
+		agree := true
+		for _, sub := range it.subs {
+			x := sub.seek(id)
+			if x < 0 {
+				return -1
+			}
+			if x != id {
+				id = x
+				agree = false
+			}
+		}
+		if agree {
+			return id
+		}
+	}
+}
+
+// An orIter is a postIter for the union of its inputs.
+type orIter struct {
+	subs []postIter
+	cur  []int // cur[i] is the current file ID in subs[i], or -1 at the end
+}
+
+func newOrIter(subs []postIter) *orIter {
+	it := &orIter{subs: subs, cur: make([]int, len(subs))}
+	for i, sub := range subs {
+		it.cur[i] = sub.seek(0)
+	}
+	return it
+}
+
+func (it *orIter) seek(id int) int {
+	min := -1
+	for i, sub := range it.subs {
+		if it.cur[i] >= 0 && it.cur[i] < id {
+			it.cur[i] = sub.seek(id)
+		}
+		if x := it.cur[i]; x >= 0 && (min < 0 || x < min) {
+			min = x
+		}
+	}
+	return min
+}
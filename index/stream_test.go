@@ -0,0 +1,65 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+var seqQueries = []*Query{
+	{Op: QAll},
+	{Op: QNone},
+	{Op: QAnd, Trigram: []string{"com"}},
+	{Op: QAnd, Trigram: []string{"rar", "com"}},
+	{Op: QAnd, Trigram: []string{"fiz", "buz"}},
+	{Op: QAnd, Trigram: []string{"fiz", "xxx"}},
+	{Op: QOr, Trigram: []string{"fiz", "buz", "xxx"}},
+	{Op: QAnd, Trigram: []string{"com"}, Sub: []*Query{
+		{Op: QOr, Trigram: []string{"rar"}, Sub: []*Query{{Op: QAnd, Trigram: []string{"fiz", "buz"}}}},
+	}},
+	{Op: QOr, Sub: []*Query{{Op: QAll}, {Op: QAnd, Trigram: []string{"rar"}}}},
+	{Op: QAnd, Sub: []*Query{{Op: QAll}}},
+}
+
+func TestPostingQuerySeq(t *testing.T) {
+	old := skipInterval
+	defer func() {
+		skipInterval = old
+	}()
+
+	for _, interval := range []int{blockLen, 1 << 30} {
+		skipInterval = interval
+		f, _ := os.CreateTemp("", "index-test")
+		defer os.Remove(f.Name())
+		buildIndex(f.Name(), []string{"/a"}, skipFiles("/a", 2000))
+		ix := Open(f.Name())
+		for _, q := range seqQueries {
+			want := ix.PostingQuery(q)
+			if have := slices.Collect(ix.PostingQuerySeq(q)); !slices.Equal(have, want) {
+				t.Errorf("interval %d: PostingQuerySeq(%s) = %v, want %v", interval, q, have, want)
+			}
+		}
+	}
+}
+
+func TestPostingQuerySeqStop(t *testing.T) {
+	f, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f.Name())
+	buildIndex(f.Name(), []string{"/a"}, skipFiles("/a", 2000))
+	ix := Open(f.Name())
+
+	var ids []int
+	for id := range ix.PostingQuerySeq(&Query{Op: QAnd, Trigram: []string{"fiz", "buz"}}) {
+		ids = append(ids, id)
+		if len(ids) == 3 {
+			break
+		}
+	}
+	if !slices.Equal(ids, []int{0, 15, 30}) {
+		t.Errorf("first three = %v, want [0 15 30]", ids)
+	}
+}
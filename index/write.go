@@ -5,18 +5,22 @@
 package index
 
 import (
-	"archive/zip"
-	"cmp"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
+	"hash"
 	"io"
+	"io/fs"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/google/codesearch/sparse"
+	"github.com/google/codesearch/transcode"
 )
 
 // Index writing.  See read.go for details of on-disk format.
@@ -30,21 +34,40 @@ import (
 // create the final posting lists by merging the temporary files as we
 // read them back in.
 //
-// It would also be useful to be able to create an index for a subset
-// of the files and then merge that index into an existing one.  This would
-// allow incremental updating of an existing index when a directory changes.
-// But we have not implemented that.
+// To update an existing index incrementally, Reuse tells the IndexWriter
+// about the old index. AddFile then skips reading files whose size and
+// modification time match the old index's file metadata, under roots
+// whose recorded options are unchanged, and mergePost
+// adds the old posting lists for those files, renumbered, as one more
+// input to the merge. The result is the same index that reading every
+// file would have produced.
 
 // An IndexWriter creates an on-disk index corresponding to a set of files.
 type IndexWriter struct {
 	LogSkip bool // log information about skipped files
 	Verbose bool // log status using package log
-	Zip     bool // index content of zip files
+	Zip     bool // index content of archives, using the registered extractors
 
-	trigram *sparse.Set // trigrams for the current file
-	buf     [32]byte    // scratch buffer
+	Limits         Limits          // limits for detecting text files
+	LimitOverrides []LimitOverride // limits for files matching patterns
+	Partial        bool            // index files exceeding the limits partially instead of skipping them
 
-	roots []Path
+	// Skipped, if non-nil, is called for each file that is not indexed,
+	// with the reason and the file's size. Like the file IDs, the calls
+	// are in the order the files were added.
+	Skipped func(name string, reason SkipReason, size int64)
+
+	// Workers is the number of files AddFile reads concurrently.
+	// If Workers is less than 2, AddFile reads each file before returning.
+	Workers int
+
+	reader *trigramReader // reader for files added synchronously
+	buf    [64]byte       // scratch buffer
+	queue  []*fileJob     // files being read by workers, in AddFile order
+	work   chan *fileJob  // files for workers to read
+
+	roots    []Path
+	rootOpts map[Path][]string // options recorded by SetRootOptions
 
 	names      *PathWriter
 	nameData   *Buffer // temp file holding list of names
@@ -52,6 +75,7 @@ type IndexWriter struct {
 	nameIndex  *Buffer // temp file holding name index
 	numName    int     // number of names written
 	nameLast   Path    // last name in list
+	meta       *Buffer // temp file holding file metadata
 	totalBytes int64
 
 	post       []postEntry // list of (trigram, file#) pairs
@@ -59,27 +83,52 @@ type IndexWriter struct {
 	postEnds   []int
 	postIndex  *Buffer // temp file holding posting list index
 	numTrigram int
+	skips      *skipWriter // skip tables for posting lists
+
+	main *Buffer // main index file
+
+	old      *Index            // old index to reuse posting data from
+	oldOpts  map[Path][]string // root options recorded in old
+	oldNames *PathReader       // names in old, advanced by reuse
+	oldID    int               // fileid of oldNames.Path()
+	oldMap   []idrange         // map from reused old fileids to new fileids
 
-	inbuf []byte  // input buffer
-	main  *Buffer // main index file
+	partial   []int          // fileids of partially indexed files
+	encodings []fileEncoding // encodings of files not in UTF-8
+	archives  []archiveMeta  // archives whose members were added
 }
 
 const npost = 64 << 20 / 8 // 64 MB worth of post entries
 
 // Create returns a new IndexWriter that will write the index to file.
 func Create(file string) *IndexWriter {
+	ix, err := create(file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return ix
+}
+
+// create is like Create but returns an error
+// if it cannot create the index file or its temporary files.
+func create(file string) (*IndexWriter, error) {
+	main, err := createBuffer(file)
+	if err != nil {
+		return nil, err
+	}
 	ix := &IndexWriter{
-		trigram:   sparse.NewSet(1 << 24),
-		nameData:  bufCreate(""),
-		nameIndex: bufCreate(""),
-		postFile:  bufCreate(""),
-		postIndex: bufCreate(""),
-		main:      bufCreate(file),
-		post:      make([]postEntry, 0, npost),
-		inbuf:     make([]byte, 1<<20),
+		reader: newTrigramReader(),
+		main:   main,
+		post:   make([]postEntry, 0, npost),
+	}
+	for _, b := range []**Buffer{&ix.nameData, &ix.nameIndex, &ix.meta, &ix.postFile, &ix.postIndex} {
+		if *b, err = createBuffer(""); err != nil {
+			ix.abort()
+			return nil, err
+		}
 	}
 	ix.names = NewPathWriter(ix.nameData, ix.nameIndex, writeVersion, nameGroupSize)
-	return ix
+	return ix, nil
 }
 
 // isValidName reports whether name is a valid name to store in the index.
@@ -123,36 +172,270 @@ func makePostEntry(trigram uint32, fileid int) postEntry {
 	return postEntry(trigram)<<40 | postEntry(fileid)
 }
 
-// Tuning constants for detecting text files.
-// A file is assumed not to be text files (and thus not indexed)
-// if it contains an invalid UTF-8 sequences, if it is longer than maxFileLength
-// bytes, if it contains a line longer than maxLineLen bytes,
-// or if it contains more than maxTextTrigrams distinct trigrams.
+// Limits are the thresholds an IndexWriter uses to decide whether a file
+// is text worth indexing. A file is assumed not to be text (and thus not
+// indexed) if it contains a NUL byte or an invalid UTF-8 sequence,
+// if it is longer than MaxFileLen bytes, if it contains a line longer
+// than MaxLineLen bytes, or if it contains more than MaxTextTrigrams
+// distinct trigrams. A zero field means to use the corresponding field
+// of the enclosing limits: DefaultLimits for IndexWriter.Limits,
+// or IndexWriter.Limits for a LimitOverride. A negative field means
+// there is no limit.
+type Limits struct {
+	MaxFileLen      int64
+	MaxLineLen      int
+	MaxTextTrigrams int
+}
+
+// DefaultLimits are the limits that an IndexWriter uses by default.
+var DefaultLimits = Limits{
+	MaxFileLen:      1 << 30,
+	MaxLineLen:      2000,
+	MaxTextTrigrams: 20000,
+}
+
+// A LimitOverride replaces some of the limits for the files matching Pattern.
+// If Pattern contains a slash, it is matched against the whole file name,
+// using path.Match; otherwise it is matched against the final element.
+// For files in archives, the name is that of the file in the archive.
+type LimitOverride struct {
+	Pattern string
+	Limits
+}
+
+// Match reports whether the override applies to the file with the given name.
+func (o *LimitOverride) Match(name string) bool {
+	name = filepath.ToSlash(name)
+	if !strings.Contains(o.Pattern, "/") {
+		name = name[strings.LastIndexAny(name, "/\x01")+1:]
+	} else if i := strings.LastIndex(name, "\x01"); i >= 0 {
+		name = name[i+1:]
+	}
+	ok, _ := path.Match(o.Pattern, name)
+	return ok
+}
+
+// or returns l with its zero fields replaced by those in base.
+func (l Limits) or(base Limits) Limits {
+	if l.MaxFileLen == 0 {
+		l.MaxFileLen = base.MaxFileLen
+	}
+	if l.MaxLineLen == 0 {
+		l.MaxLineLen = base.MaxLineLen
+	}
+	if l.MaxTextTrigrams == 0 {
+		l.MaxTextTrigrams = base.MaxTextTrigrams
+	}
+	return l
+}
+
+// limitsFor returns the limits that apply to the file with the given name.
+// When several overrides match the name, the last one applies.
+func (ix *IndexWriter) limitsFor(name string) Limits {
+	l := ix.Limits.or(DefaultLimits)
+	for i := len(ix.LimitOverrides) - 1; i >= 0; i-- {
+		if o := &ix.LimitOverrides[i]; o.Match(name) {
+			return o.Limits.or(l)
+		}
+	}
+	return l
+}
+
+// A SkipReason is the reason an IndexWriter did not index a file,
+// or only indexed part of it.
+type SkipReason int
+
 const (
-	maxFileLen      = 1 << 30
-	maxLineLen      = 2000
-	maxTextTrigrams = 20000
+	SkipNUL       SkipReason = 1 + iota // contains a NUL byte
+	SkipUTF8                            // contains invalid UTF-8
+	SkipTooLong                         // longer than Limits.MaxFileLen
+	SkipLongLines                       // has a line longer than Limits.MaxLineLen
+	SkipTrigrams                        // has more than Limits.MaxTextTrigrams trigrams
+	SkipName                            // name cannot be stored in the index
 )
 
+var skipReasons = []string{
+	SkipNUL:       "NUL",
+	SkipUTF8:      "invalid UTF-8",
+	SkipTooLong:   "too long",
+	SkipLongLines: "long lines",
+	SkipTrigrams:  "too many trigrams",
+	SkipName:      "malformed name",
+}
+
+func (r SkipReason) String() string {
+	if 0 < r && int(r) < len(skipReasons) {
+		return skipReasons[r]
+	}
+	return fmt.Sprintf("SkipReason(%d)", int(r))
+}
+
+// skip records that the file with the given name and size was not indexed.
+func (ix *IndexWriter) skip(name string, reason SkipReason, size int64) {
+	if ix.LogSkip {
+		log.Printf("%s: %v, ignoring\n", name, reason)
+	}
+	if ix.Skipped != nil {
+		ix.Skipped(name, reason, size)
+	}
+}
+
 // AddRoots adds the given roots to the index's list of roots.
 func (ix *IndexWriter) AddRoots(roots []Path) {
 	ix.roots = append(ix.roots, roots...)
 }
 
+// Reuse arranges for ix to reuse the posting data in old for files
+// that are unchanged since old was written, instead of reading them again.
+// A file is considered unchanged if its size and modification time
+// match the metadata recorded in old. Only files under roots whose
+// options, set with SetRootOptions before adding the root's files, match
+// those recorded in old are reused, so the options should include the
+// limits and other settings that affect how a file is indexed.
+// The files must be added in sorted order, and old must remain open
+// until ix has been flushed.
+func (ix *IndexWriter) Reuse(old *Index) {
+	if !old.HasMeta() {
+		return
+	}
+	ix.old = old
+	ix.oldOpts = old.rootOptions()
+	ix.oldNames = old.NamesAt(0, old.numName)
+	ix.oldID = 0
+}
+
+// reuse reports whether the file with the given name and info
+// is unchanged in the old index, and if so, adds it to ix
+// using the old index's data. An archive whose members were
+// indexed is unchanged if the archive file is, and reusing it
+// adds all its members.
+func (ix *IndexWriter) reuse(name string, info os.FileInfo) bool {
+	if ix.old == nil {
+		return false
+	}
+	p := MakePath(name)
+	if !ix.sameOptions(p) {
+		return false
+	}
+	for ix.oldNames.Valid() && ix.oldNames.Path().Compare(p) < 0 {
+		ix.oldNames.Next()
+		ix.oldID++
+	}
+	if ix.isArchive(name) && ix.reuseArchive(name, info) {
+		return true
+	}
+	if !ix.oldNames.Valid() || ix.oldNames.Path() != p {
+		return false
+	}
+	m, _ := ix.old.Meta(ix.oldID)
+	if m.ModTime.IsZero() || m.Size != info.Size() || !m.ModTime.Equal(info.ModTime()) {
+		return false
+	}
+
+	ix.wait() // files being read by workers come first
+	ix.reuseOld(p, m)
+	if ix.Verbose {
+		log.Printf("%d reused %s\n", m.Size, name)
+	}
+	return true
+}
+
+// reuseArchive is like reuse for the archive with the given name,
+// whose members, if the old index has them, come next in oldNames.
+func (ix *IndexWriter) reuseArchive(name string, info os.FileInfo) bool {
+	prefix := name + "\x01"
+	if !ix.oldNames.Valid() || !strings.HasPrefix(ix.oldNames.Path().String(), prefix) {
+		return false
+	}
+	a, ok := ix.old.archive(ix.oldID)
+	if !ok || a.size != info.Size() || !a.modTime.Equal(info.ModTime()) {
+		return false
+	}
+
+	ix.wait()
+	first := ix.numName
+	for ix.oldNames.Valid() && strings.HasPrefix(ix.oldNames.Path().String(), prefix) {
+		m, _ := ix.old.Meta(ix.oldID)
+		ix.reuseOld(ix.oldNames.Path(), m)
+		ix.oldNames.Next()
+		ix.oldID++
+	}
+	ix.archives = append(ix.archives, archiveMeta{first, a.size, a.modTime})
+	if ix.Verbose {
+		log.Printf("%d reused %s\n", a.size, name)
+	}
+	return true
+}
+
+// reuseOld adds the file with name p and metadata m,
+// which is at ix.oldID in the old index, using the old index's data.
+func (ix *IndexWriter) reuseOld(p Path, m FileMeta) {
+	fileid := ix.addName(p)
+	if ix.old.Partial(ix.oldID) {
+		ix.partial = append(ix.partial, fileid)
+	}
+	if enc := ix.old.encoding(ix.oldID); enc != transcode.UTF8 {
+		ix.encodings = append(ix.encodings, fileEncoding{fileid, enc})
+	}
+	ix.old.copyMeta(ix.meta, ix.oldID, ix.oldID+1)
+	ix.totalBytes += m.Size
+	if n := len(ix.oldMap); n > 0 && ix.oldMap[n-1].hi == ix.oldID && ix.oldMap[n-1].new+ix.oldID-ix.oldMap[n-1].lo == fileid {
+		ix.oldMap[n-1].hi++
+	} else {
+		ix.oldMap = append(ix.oldMap, idrange{ix.oldID, ix.oldID + 1, fileid})
+	}
+}
+
+// sameOptions reports whether the root containing p has the same
+// options as in the old index. A file under none of the roots
+// is taken to have the same options.
+func (ix *IndexWriter) sameOptions(p Path) bool {
+	for _, root := range ix.roots {
+		if p.HasPathPrefix(root) {
+			return slices.Equal(ix.rootOpts[root], ix.oldOpts[root])
+		}
+	}
+	return true
+}
+
 // AddFile adds the file with the given name (opened using os.Open)
 // to the index.  It logs errors using package log.
+// If ix.Workers > 1, AddFile may return before reading the file,
+// in which case errors reading it are logged but not returned.
 func (ix *IndexWriter) AddFile(name string) error {
-	f, err := os.Open(name)
+	return ix.addFile(osFS{}, name, name)
+}
+
+// addFile adds the file at path in fsys to the index under the given name.
+func (ix *IndexWriter) addFile(fsys fs.FS, path, name string) error {
+	if ix.old != nil {
+		if info, err := fs.Stat(fsys, path); err == nil && ix.reuse(name, info) {
+			return nil
+		}
+	}
+	if err := checkName(name); err != nil {
+		var size int64
+		if info, err := fs.Stat(fsys, path); err == nil {
+			size = info.Size()
+		}
+		ix.wait()
+		ix.skip(name, SkipName, size)
+		return err
+	}
+	f, err := fsys.Open(path)
 	if err != nil {
 		return err
 	}
+	if ix.Workers > 1 && !ix.isArchive(name) {
+		ix.addAsync(name, f)
+		return nil
+	}
 	defer f.Close()
 	return ix.Add(name, f)
 }
 
-// Add adds the file f to the index under the given name.
-// It logs errors using package log.
-func (ix *IndexWriter) Add(name string, f io.Reader) error {
+// checkName returns an error if name is not valid to store in the index.
+func checkName(name string) error {
 	if !isValidName(name) {
 		for _, f := range strings.Split(name, string(filepath.Separator)) {
 			if !isValidName(f) {
@@ -161,79 +444,153 @@ func (ix *IndexWriter) Add(name string, f io.Reader) error {
 		}
 		return fmt.Errorf("malformed name %q", name)
 	}
+	return nil
+}
 
-	if strings.HasSuffix(name, ".zip") && ix.Zip {
-		f, ok := f.(interface {
-			io.ReaderAt
-			Stat() (os.FileInfo, error)
-		})
-		if !ok {
-			goto NoZip
-		}
-		info, err := f.Stat()
-		if err != nil || !info.Mode().IsRegular() {
-			goto NoZip
-		}
-		r, err := zip.NewReader(f, info.Size())
-		if err != nil {
+// Add adds the file f to the index under the given name.
+// It logs errors using package log.
+func (ix *IndexWriter) Add(name string, f io.Reader) error {
+	ix.wait()
+	if err := checkName(name); err != nil {
+		ix.skip(name, SkipName, fileSize(f, 0))
+		return err
+	}
+
+	if ix.isArchive(name) {
+		if ok, err := ix.addArchive(name, f); ok || err != nil {
 			return err
 		}
-		files := slices.Clone(r.File)
-		slices.SortFunc(files, func(x, y *zip.File) int {
-			for i := 0; i < len(x.Name) && i < len(y.Name); i++ {
-				if x.Name[i] == y.Name[i] {
-					continue
-				}
-				if x.Name[i] == '/' {
-					return -1
-				}
-				if y.Name[i] == '/' {
-					return +1
-				}
-				return cmp.Compare(x.Name[i], y.Name[i])
-			}
-			return cmp.Compare(len(x.Name), len(y.Name))
-		})
-		for _, file := range files {
-			r, err := file.Open()
-			if err != nil {
-				println("no3", name)
-
-				log.Printf("%s: %v", r, err)
-				continue
-			}
-			ix.add(name+"\x01"+file.Name, r)
-			r.Close()
+	}
+	return ix.add(name, f, modTime(f))
+}
+
+// fileSize returns the size of f,
+// or n if f cannot report its size.
+func fileSize(f io.Reader, n int64) int64 {
+	if f, ok := f.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := f.Stat(); err == nil {
+			return info.Size()
+		}
+	}
+	return n
+}
+
+// modTime returns the modification time of f,
+// or the zero time if f cannot report one.
+func modTime(f io.Reader) time.Time {
+	if f, ok := f.(interface{ Stat() (os.FileInfo, error) }); ok {
+		if info, err := f.Stat(); err == nil {
+			return info.ModTime()
 		}
+	}
+	return time.Time{}
+}
+
+func (ix *IndexWriter) add(name string, f io.Reader, mtime time.Time) error {
+	t, err := ix.reader.read(name, f, ix.limitsFor(name), ix.partialOK())
+	if err != nil {
 		return err
 	}
+	t.meta.ModTime = mtime
+	if t.skip != 0 {
+		t.meta.Size = fileSize(f, t.meta.Size)
+	}
+	ix.addTrigrams(name, &t)
+	return nil
+}
+
+// A trigramReader reads files and collects their trigrams.
+// Each goroutine reading files needs its own trigramReader.
+type trigramReader struct {
+	trigram *sparse.PagedSet  // trigrams for the current file
+	raw     hashReader        // content of the current file
+	text    *transcode.Reader // content decoded to UTF-8
+	inbuf   []byte            // input buffer
+}
+
+func newTrigramReader() *trigramReader {
+	r := &trigramReader{
+		trigram: sparse.NewPagedSet(1 << 24),
+		raw:     hashReader{h: sha256.New()},
+		inbuf:   make([]byte, 1<<20),
+	}
+	r.text = transcode.NewReader(&r.raw)
+	return r
+}
+
+// A hashReader hashes and counts the bytes read from r.
+type hashReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func (h *hashReader) reset(r io.Reader) {
+	h.r = r
+	h.h.Reset()
+	h.n = 0
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.r.Read(p)
+	h.h.Write(p[:n])
+	h.n += int64(n)
+	return n, err
+}
 
-NoZip:
-	return ix.add(name, f)
+// A fileTrigrams is the result of reading a single file.
+type fileTrigrams struct {
+	meta     FileMeta   // size and hash; the caller sets ModTime
+	trigrams []uint32   // trigrams in the file
+	skip     SkipReason // reason not to index the file, or 0
+	partial  SkipReason // reason the file is only partially indexed, or 0
 }
 
-func (ix *IndexWriter) add(name string, f io.Reader) error {
-	ix.trigram.Reset()
+// read reads f, which has the given name, and returns its trigrams.
+// If the file exceeds the limits, read returns a fileTrigrams saying to
+// skip it, unless partial is true, in which case it returns the trigrams
+// in the part of the file within the limits: the first limits.MaxFileLen
+// bytes, with each line truncated to limits.MaxLineLen bytes, until
+// limits.MaxTextTrigrams distinct trigrams have been seen.
+// The trigram list is only valid until the next call to read.
+func (r *trigramReader) read(name string, f io.Reader, limits Limits, partial bool) (fileTrigrams, error) {
+	r.trigram.Reset()
+	r.raw.reset(f)
+	r.text.Reset(&r.raw)
 	var (
 		c       = byte(0)
 		i       = 0
-		buf     = ix.inbuf[:0]
+		buf     = r.inbuf[:0]
 		tv      = uint32(0)
 		n       = int64(0)
 		linelen = 0
+		t       fileTrigrams
 	)
+	skip := func(reason SkipReason) (fileTrigrams, error) {
+		return fileTrigrams{meta: FileMeta{Size: n}, skip: reason}, nil
+	}
+	exceeded := func(reason SkipReason) {
+		if t.partial == 0 {
+			t.partial = reason
+		}
+	}
+	enc, err := r.text.Encoding()
+	if err != nil {
+		return fileTrigrams{}, err
+	}
+	t.meta.Encoding = enc
 	for {
 		tv = (tv << 8) & (1<<24 - 1)
 		if i >= len(buf) {
-			n, err := f.Read(buf[:cap(buf)])
+			n, err := r.text.Read(buf[:cap(buf)])
 			if n == 0 {
 				if err != nil {
 					if err == io.EOF {
 						break
 					}
-					return err
+					return fileTrigrams{}, err
 				}
-				return fmt.Errorf("%s: 0-length read", name)
+				return fileTrigrams{}, fmt.Errorf("%s: 0-length read", name)
 			}
 			buf = buf[:n]
 			i = 0
@@ -241,70 +598,113 @@ func (ix *IndexWriter) add(name string, f io.Reader) error {
 		c = buf[i]
 		i++
 		tv |= uint32(c)
-		if n++; n >= 3 {
-			ix.trigram.Add(tv)
-		}
+		n++
 		if c == 0 {
-			if ix.LogSkip {
-				log.Printf("%s: contains NUL, ignoring\n", name)
-			}
-			return nil
+			return skip(SkipNUL)
 		}
 		if !validUTF8((tv>>8)&0xFF, tv&0xFF) {
-			if ix.LogSkip {
-				log.Printf("%s: invalid UTF-8, ignoring\n", name)
-			}
-			return nil
+			return skip(SkipUTF8)
 		}
-		if n > maxFileLen {
-			if ix.LogSkip {
-				log.Printf("%s: too long, ignoring\n", name)
+		if limits.MaxFileLen >= 0 && n > limits.MaxFileLen {
+			if !partial {
+				return skip(SkipTooLong)
 			}
-			return nil
+			exceeded(SkipTooLong)
+			// Hash the rest of the file without indexing it.
+			if _, err := io.Copy(io.Discard, &r.raw); err != nil {
+				return fileTrigrams{}, err
+			}
+			break
 		}
-		if linelen++; linelen > maxLineLen {
-			if ix.LogSkip {
-				log.Printf("%s: very long lines, ignoring\n", name)
+		if linelen++; limits.MaxLineLen >= 0 && linelen > limits.MaxLineLen {
+			if !partial {
+				return skip(SkipLongLines)
+			}
+			exceeded(SkipLongLines)
+		} else if n >= 3 {
+			if partial && limits.MaxTextTrigrams >= 0 && r.trigram.Len() >= limits.MaxTextTrigrams && !r.trigram.Has(tv) {
+				exceeded(SkipTrigrams)
+			} else {
+				r.trigram.Add(tv)
 			}
-			return nil
 		}
 		if c == '\n' {
 			linelen = 0
 		}
 	}
-	if ix.trigram.Len() > maxTextTrigrams {
-		if ix.LogSkip {
-			log.Printf("%s: too many trigrams, probably not text, ignoring\n", name)
-		}
-		return nil
+	if limits.MaxTextTrigrams >= 0 && r.trigram.Len() > limits.MaxTextTrigrams {
+		return skip(SkipTrigrams)
 	}
-	ix.totalBytes += n
+	// The encoding may have changed from UTF-8 to Latin-1 during reading.
+	t.meta.Encoding, _ = r.text.Encoding()
+	t.meta.Size = r.raw.n
+	t.trigrams = r.trigram.Dense()
+	r.raw.h.Sum(t.meta.Hash[:0])
+	return t, nil
+}
+
+// addTrigrams adds the file with the given name and trigrams to the index,
+// unless t says to skip it.
+func (ix *IndexWriter) addTrigrams(name string, t *fileTrigrams) {
+	if t.skip != 0 {
+		ix.skip(name, t.skip, t.meta.Size)
+		return
+	}
+	if t.partial != 0 && ix.LogSkip {
+		log.Printf("%s: %s, indexing partially\n", name, t.partial)
+	}
+	ix.totalBytes += t.meta.Size
 
 	if ix.Verbose {
-		log.Printf("%d %d %s\n", n, ix.trigram.Len(), name)
+		log.Printf("%d %d %s\n", t.meta.Size, len(t.trigrams), name)
 	}
 
 	fileid := ix.addName(MakePath(name))
-	for _, trigram := range ix.trigram.Dense() {
+	if t.partial != 0 {
+		ix.partial = append(ix.partial, fileid)
+	}
+	if t.meta.Encoding != transcode.UTF8 && writeVersion >= 3 {
+		ix.encodings = append(ix.encodings, fileEncoding{fileid, t.meta.Encoding})
+	}
+	if writeVersion >= 3 {
+		ix.meta.Write(appendMeta(ix.buf[:0], &t.meta))
+	}
+	for _, trigram := range t.trigrams {
 		if len(ix.post) >= cap(ix.post) {
 			ix.flushPost()
 		}
 		ix.post = append(ix.post, makePostEntry(trigram, fileid))
 	}
-	return nil
 }
 
 // Flush flushes the index entry to the target file.
 func (ix *IndexWriter) Flush() {
+	if err := ix.flush(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// flush is like Flush but returns an error writing the index.
+func (ix *IndexWriter) flush() error {
+	ix.stopWorkers()
+	if err := ix.postFile.err; err != nil {
+		return err
+	}
+
 	if writeVersion == 1 {
 		ix.addName(Path{})
 	}
 
 	var off [8]int
-	if writeVersion == 1 {
+	switch writeVersion {
+	case 1:
 		ix.main.WriteString(magicV1)
-	} else {
+	case 2:
 		ix.main.WriteString(magicV2)
+	case 3:
+		ix.main.WriteString(magicV3)
+	default:
+		ix.main.WriteString(magicV4)
 	}
 
 	// Path list.
@@ -334,6 +734,35 @@ func (ix *IndexWriter) Flush() {
 	copyFile(ix.main, ix.nameIndex) // (numName+15)/16 entries
 	ix.main.Align(16)
 
+	// Optional sections.
+	var sections []section
+	if writeVersion >= 3 {
+		start := ix.main.Offset()
+		copyFile(ix.main, ix.meta)
+		sections = append(sections, section{sectionFileMeta, start, ix.main.Offset() - start})
+		ix.main.Align(16)
+		if sec, ok := ix.skips.writeSection(ix.main); ok {
+			sections = append(sections, sec)
+			ix.main.Align(16)
+		}
+		if sec, ok := writeRootOptions(ix.main, ix.roots, ix.rootOpts); ok {
+			sections = append(sections, sec)
+			ix.main.Align(16)
+		}
+		if sec, ok := writePartial(ix.main, ix.partial); ok {
+			sections = append(sections, sec)
+			ix.main.Align(16)
+		}
+		if sec, ok := writeEncodings(ix.main, ix.encodings); ok {
+			sections = append(sections, sec)
+			ix.main.Align(16)
+		}
+		if sec, ok := writeArchives(ix.main, ix.archives); ok {
+			sections = append(sections, sec)
+			ix.main.Align(16)
+		}
+	}
+
 	// Posting index.
 	off[7] = ix.main.Offset()
 	copyFile(ix.main, ix.postIndex) // to end of file
@@ -349,10 +778,20 @@ func (ix *IndexWriter) Flush() {
 		for _, v := range off {
 			ix.main.WriteUint(v)
 		}
-		ix.main.WriteString(trailerMagicV2)
+		switch writeVersion {
+		case 2:
+			ix.main.WriteString(trailerMagicV2)
+		case 3:
+			writeSections(ix.main, sections)
+			ix.main.WriteString(trailerMagicV3)
+		default:
+			writeSections(ix.main, sections)
+			ix.main.WriteString(trailerMagicV4)
+		}
 	}
 
 	os.Remove(ix.nameData.name)
+	os.Remove(ix.meta.name)
 	os.Remove(ix.postFile.name)
 	os.Remove(ix.nameIndex.name)
 	os.Remove(ix.postIndex.name)
@@ -360,21 +799,33 @@ func (ix *IndexWriter) Flush() {
 	log.Printf("%d data bytes, %d index bytes", ix.totalBytes, ix.main.Offset())
 
 	ix.main.Flush()
+	if err := ix.main.file.Close(); err != nil {
+		ix.main.fail(err)
+	}
+	return ix.main.err
 }
 
+// copyFile copies the data written to src to the end of dst.
+// An error writing either one becomes an error writing dst.
 func copyFile(dst, src *Buffer) {
 	dst.Flush()
-	n, err := io.Copy(dst.file, src.finish())
-	if err != nil {
-		log.Fatalf("copying %s to %s: %v", src.name, dst.name, err)
+	f := src.finish()
+	switch {
+	case src.err != nil:
+		dst.fail(src.err)
+	case dst.err == nil:
+		n, err := io.Copy(dst.file, f)
+		if err != nil {
+			dst.fail(fmt.Errorf("copying %s to %s: %v", src.name, dst.name, err))
+		}
+		dst.fileOff += n
 	}
-	dst.fileOff += n
 }
 
 // addName adds the file with the given name to the index.
 // It returns the assigned file ID number.
 func (ix *IndexWriter) addName(name Path) int {
-	if writeVersion == 2 {
+	if writeVersion >= 2 {
 		if name.String() == "" {
 			log.Fatalf("index of empty name")
 		}
@@ -389,6 +840,15 @@ func (ix *IndexWriter) addName(name Path) int {
 	return id
 }
 
+// reused returns the number of files reused from the old index.
+func (ix *IndexWriter) reused() int {
+	n := 0
+	for _, r := range ix.oldMap {
+		n += r.hi - r.lo
+	}
+	return n
+}
+
 // flushPost writes ix.post to a new temporary file and
 // clears the slice.
 func (ix *IndexWriter) flushPost() {
@@ -434,24 +894,16 @@ func (ix *IndexWriter) mergePost(out *Buffer) {
 	}
 	sortPost(ix.post)
 	h.addMem(ix.post)
+	if len(ix.oldMap) > 0 {
+		log.Printf("merge %d reused files", ix.reused())
+		h.addIndex(ix.old, ix.oldMap)
+	}
 
 	var w postDataWriter
 	w.init(out, ix.postIndex)
-
-	e := h.next()
-	for {
-		t := e.trigram()
-		w.trigram(t)
-		for ; e.trigram() == t && t != invalidTrigram; e = h.next() {
-			w.fileid(e.fileid())
-		}
-		w.endTrigram()
-		if t == invalidTrigram {
-			break
-		}
-	}
-	w.flush()
+	h.writeTo(&w)
 	ix.numTrigram = w.numTrigram
+	ix.skips = w.skips
 }
 
 // A postChunk represents a chunk of post entries flushed to disk or
@@ -480,6 +932,42 @@ func (h *postHeap) addFile(w *Buffer, ends []int) {
 	}
 }
 
+// writeTo writes the posting lists formed by the entries in h to w.
+func (h *postHeap) writeTo(w *postDataWriter) {
+	e := h.next()
+	for {
+		t := e.trigram()
+		w.trigram(t)
+		for ; e.trigram() == t && t != invalidTrigram; e = h.next() {
+			w.fileid(e.fileid())
+		}
+		w.endTrigram()
+		if t == invalidTrigram {
+			break
+		}
+	}
+	w.flush()
+}
+
+// addIndex adds the posting lists from ix for the fileids in idmap,
+// renumbered according to idmap.
+func (h *postHeap) addIndex(ix *Index, idmap []idrange) {
+	if len(idmap) == 0 {
+		return
+	}
+	var r postMapReader
+	r.init(ix, idmap)
+	h.add(func() (postEntry, bool) {
+		for r.trigram != ^uint32(0) {
+			if r.nextId() {
+				return makePostEntry(r.trigram, r.fileid), true
+			}
+			r.nextTrigram()
+		}
+		return postEntry(0), false
+	})
+}
+
 func (h *postHeap) addMem(x []postEntry) {
 	h.add(func() (postEntry, bool) {
 		if len(x) == 0 {
@@ -590,18 +1078,33 @@ func (h *postHeap) siftUp(j int) {
 }
 
 // A Buffer is a convenience wrapper: a closeable bufio.Writer.
+//
+// A Buffer records the first error writing its file and discards
+// the data written after it. The owner of the Buffer checks err
+// once it has written everything.
 type Buffer struct {
 	name    string
 	file    *os.File
 	fileOff int64
 	buf     []byte
 	tmp     [8]byte
+	err     error
 }
 
 // bufCreate creates a new file with the given name and returns a
 // corresponding Buffer.  If name is empty, bufCreate uses a
 // temporary file.
 func bufCreate(name string) *Buffer {
+	b, err := createBuffer(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return b
+}
+
+// createBuffer is like bufCreate but returns an error
+// if it cannot create the file.
+func createBuffer(name string) (*Buffer, error) {
 	var (
 		f   *os.File
 		err error
@@ -612,12 +1115,33 @@ func bufCreate(name string) *Buffer {
 		f, err = os.CreateTemp("", "csearch")
 	}
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	return &Buffer{
 		name: f.Name(),
 		buf:  make([]byte, 0, 256<<10),
 		file: f,
+	}, nil
+}
+
+// fail records err as an error writing b, unless it already has one.
+func (b *Buffer) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// write writes x to b's file, unless writing has failed.
+func (b *Buffer) write(x []byte) {
+	if b.err != nil || b.file == nil {
+		return
+	}
+	n, err := b.file.Write(x)
+	if err == nil && n != len(x) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		b.fail(fmt.Errorf("writing %s: %v", b.name, err))
 	}
 }
 
@@ -626,9 +1150,7 @@ func (b *Buffer) Write(x []byte) {
 	if len(x) > n {
 		b.Flush()
 		if b.file != nil && len(x) >= cap(b.buf) {
-			if _, err := b.file.Write(x); err != nil {
-				log.Fatalf("writing %s: %v", b.name, err)
-			}
+			b.write(x)
 			b.fileOff += int64(len(x))
 			return
 		}
@@ -649,9 +1171,7 @@ func (b *Buffer) WriteString(s string) {
 	if len(s) > n {
 		b.Flush()
 		if len(s) >= cap(b.buf) {
-			if _, err := b.file.WriteString(s); err != nil {
-				log.Fatalf("writing %s: %v", b.name, err)
-			}
+			b.write([]byte(s))
 			b.fileOff += int64(len(s))
 			return
 		}
@@ -672,13 +1192,7 @@ func (b *Buffer) Flush() {
 	if len(b.buf) == 0 || b.file == nil {
 		return
 	}
-	n, err := b.file.Write(b.buf)
-	if err != nil {
-		log.Fatalf("writing %s: %v", b.name, err)
-	}
-	if n != len(b.buf) {
-		log.Fatalf("writing %s: unexpected short write", b.name)
-	}
+	b.write(b.buf)
 	b.fileOff += int64(len(b.buf))
 	b.buf = b.buf[:0]
 }
@@ -687,7 +1201,9 @@ func (b *Buffer) Flush() {
 func (b *Buffer) finish() *os.File {
 	b.Flush()
 	f := b.file
-	f.Seek(0, 0)
+	if _, err := f.Seek(0, 0); err != nil {
+		b.fail(err)
+	}
 	return f
 }
 
@@ -820,6 +1336,12 @@ func sortPost(post []postEntry) {
 	}
 }
 
+// A postDataWriter writes posting lists. Temporary files and older
+// versions use only the γ coding, which the writer streams out as it
+// is given the file IDs. Version 4 indexes choose each list's encoding
+// from the whole list, so the writer holds the file IDs of a list until
+// its end, unless there are more than maxBufferedIDs of them, in which
+// case it falls back to streaming the γ coding.
 type postDataWriter struct {
 	out           *Buffer
 	postIndexFile *Buffer
@@ -827,14 +1349,23 @@ type postDataWriter struct {
 	lastOffset    int
 	count         int
 	offset        int
-	lastID        int
+	ids           []int // buffered file IDs in current list
+	gamma         bool  // streaming current list in γ coding
+	start         int   // offset of current list's data
+	last          int   // last file ID written in γ coding
+	ngamma        int   // number of file IDs written in γ coding
 	t             uint32
 	delta         deltaWriter
 	numTrigram    int
 	tmp           [32]byte
 	block         []byte
+	skips         *skipWriter // skip tables; nil if not writing them
 }
 
+// maxBufferedIDs is the maximum number of file IDs a postDataWriter
+// holds in memory to choose a list's encoding. It is a variable for testing.
+var maxBufferedIDs = 1 << 20
+
 func (w *postDataWriter) flush() {
 	if w.postIndexFile != nil && len(w.block) > 0 {
 		w.postIndexFile.Write(w.block[:cap(w.block)])
@@ -850,6 +1381,10 @@ func (w *postDataWriter) init(postData, postIndex *Buffer) {
 	w.lastOffset = w.base
 	w.postIndexFile = postIndex
 	w.block = make([]byte, 0, postBlockSize)
+	w.skips = nil
+	if postIndex != nil && writeVersion >= 3 {
+		w.skips = &skipWriter{interval: skipInterval}
+	}
 }
 
 func (w *postDataWriter) trigram(t uint32) {
@@ -859,23 +1394,89 @@ func (w *postDataWriter) trigram(t uint32) {
 	w.offset = w.out.Offset()
 	w.count = 0
 	w.t = t
-	w.lastID = -1
-	w.numTrigram++
+	w.ids = w.ids[:0]
+}
+
+// begin writes the start of the current list.
+func (w *postDataWriter) begin() {
 	w.out.WriteTrigram(w.t)
+	w.numTrigram++
+	w.start = w.out.Offset()
+	w.last = -1
+	w.ngamma = 0
+	w.gamma = w.postIndexFile == nil || writeVersion < 4
 }
 
 func (w *postDataWriter) fileid(id int) {
-	w.delta.Write(id - w.lastID)
-	w.lastID = id
+	if w.count == 0 {
+		w.begin()
+	}
 	w.count++
+	if w.gamma {
+		w.writeGamma(id)
+		return
+	}
+	w.ids = append(w.ids, id)
+	if len(w.ids) > maxBufferedIDs {
+		w.gamma = true
+		for _, id := range w.ids {
+			w.writeGamma(id)
+		}
+		w.ids = w.ids[:0]
+	}
+}
+
+// writeGamma writes id to the current list in γ coding.
+func (w *postDataWriter) writeGamma(id int) {
+	w.delta.Write(id - w.last)
+	w.last = id
+	w.ngamma++
+	if w.skips != nil && w.ngamma%w.skips.interval == 0 {
+		w.skips.add(id, (w.out.Offset()-w.start)*8+int(w.delta.nb))
+	}
 }
 
 func (w *postDataWriter) endTrigram() {
-	w.delta.Write(0)
-	w.delta.Flush()
+	if w.count == 0 {
+		if w.t != invalidTrigram {
+			// Omit empty lists, except for the terminating one.
+			return
+		}
+		w.begin()
+		w.gamma = true
+	}
+
+	enc := encGamma
+	if !w.gamma {
+		enc = chooseEncoding(w.ids)
+	}
+	switch enc {
+	case encGamma:
+		for _, id := range w.ids {
+			w.writeGamma(id)
+		}
+		w.delta.Write(0)
+		w.delta.Flush()
+	case encBitmap:
+		writeBitmap(w.out, w.ids)
+	case encBlock:
+		last := -1
+		for i := 0; i < len(w.ids); i += blockLen {
+			ids := w.ids[i:min(i+blockLen, len(w.ids))]
+			writeBlock(w.out, ids, last)
+			last = ids[len(ids)-1]
+			// Skip table entries must fall at block boundaries.
+			if end := i + len(ids); w.skips != nil && w.skips.interval%blockLen == 0 && end%w.skips.interval == 0 {
+				w.skips.add(last, (w.out.Offset()-w.start)*8)
+			}
+		}
+	}
 	if w.postIndexFile == nil {
 		return
 	}
+	if w.skips != nil {
+		w.skips.endList(w.t)
+	}
 	if writeVersion == 1 {
 		w.postIndexFile.WriteTrigram(w.t)
 		w.postIndexFile.WriteUint(w.count)
@@ -891,12 +1492,19 @@ func (w *postDataWriter) endTrigram() {
 	n := 3
 	n += binary.PutUvarint(buf[n:], uint64(w.count))
 	n1 := binary.PutUvarint(buf[n:], uint64(w.offset-w.lastOffset))
-	if len(w.block)+n+n1 > cap(w.block) {
+	e := 0
+	if writeVersion >= 4 {
+		e = 1
+	}
+	if len(w.block)+n+n1+e > cap(w.block) {
 		w.postIndexFile.Write(w.block[:cap(w.block)])
 		clear(w.block)
 		w.block = w.block[:0]
 		n1 = binary.PutUvarint(buf[n:], uint64(w.offset-w.base))
 	}
-	w.block = append(w.block, buf[:n+n1]...)
+	if e > 0 {
+		buf[n+n1] = byte(enc)
+	}
+	w.block = append(w.block, buf[:n+n1+e]...)
 	w.lastOffset = w.offset
 }
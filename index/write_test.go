@@ -7,10 +7,14 @@ package index
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"testing"
@@ -159,6 +163,173 @@ var trivialIndexV2 = join(
 	"\ncsearch trlr 2\n",
 )
 
+var trivialIndexV3 = join(
+	// header
+	"csearch index 3\n",
+
+	// list of paths (empty)
+
+	// list of names
+	pad(16,
+		"\x00\x06afile4",
+		"\x00\x02f0",
+		"\x01\x04ile1",
+		"\x04\x013",
+		"\x04\x015",
+		"\x00\x08the/file",
+	),
+
+	// list of posting lists
+	pad(16,
+		"\na\n", fileList64(2), // file1; 1-byte file list
+		"\nab", fileList64(3, 5), // file3, thefile2; 2-byte file list
+		"\nda", fileList64(0), // afile4; 1-byte file list
+		"\nxy", fileList64(4), // file5; 1-byte file list
+		"ab\n", fileList64(5), // thefile2; 1-byte file list
+		"abc", fileList64(0, 3), // afile4, file3; 2-byte file list
+		"bc\n", fileList64(0, 3), // afile4, file3; 2-byte file list
+		"dab", fileList64(0), // afile4; 1-byte file list
+		"xyz", fileList64(4), // file5; 1-byte file list
+		"yzw", fileList64(4), // file5; 1-byte file list
+		"zw\n", fileList64(4), // file5; 1-byte file list
+		"\xff\xff\xff", fileList64(),
+	),
+
+	// name index
+	pad(16,
+		u64(0),
+	),
+
+	// file metadata section
+	pad(16,
+		meta(trivialFiles["afile4"]),
+		meta(trivialFiles["f0"]),
+		meta(trivialFiles["file1"]),
+		meta(trivialFiles["file3"]),
+		meta(trivialFiles["file5"]),
+		meta(trivialFiles["the/file"]),
+	),
+
+	// posting list index block
+	pad(postBlockSize,
+		"\na\n", uv(1), uv(0),
+		"\nab", uv(2), uv(5),
+		"\nda", uv(1), uv(6),
+		"\nxy", uv(1), uv(5),
+		"ab\n", uv(1), uv(5),
+		"abc", uv(2), uv(5),
+		"bc\n", uv(2), uv(5),
+		"dab", uv(1), uv(5),
+		"xyz", uv(1), uv(5),
+		"yzw", uv(1), uv(5),
+		"zw\n", uv(1), uv(5),
+		"\xff\xff\xff", uv(0), uv(5),
+	),
+
+	// trailer
+	u64(0x10),  // offset to list of paths
+	u64(0),     // number of paths
+	u64(0x10),  // offset to list of names
+	u64(6),     // number of names
+	u64(0x40),  // offset to posting lists
+	u64(12),    // number of posting lists / trigrams
+	u64(0x80),  // offset to name index
+	u64(0x1b0), // offset to posting index
+
+	// optional sections
+	"filemeta", u64(0x90), u64(6*metaRecordSize),
+	u64(1), // number of optional sections
+
+	"\ncsearch trlr 3\n",
+)
+
+var trivialIndexV4 = join(
+	// header
+	"csearch index 4\n",
+
+	// list of paths (empty)
+
+	// list of names
+	pad(16,
+		"\x00\x06afile4",
+		"\x00\x02f0",
+		"\x01\x04ile1",
+		"\x04\x013",
+		"\x04\x015",
+		"\x00\x08the/file",
+	),
+
+	// list of posting lists
+	pad(16,
+		"\na\n", blockList(2), // file1; 2-byte block
+		"\nab", bitmapList(3, 5), // file3, thefile2; 3-byte bitmap
+		"\nda", blockList(0), // afile4; 1-byte block
+		"\nxy", blockList(4), // file5; 2-byte block
+		"ab\n", blockList(5), // thefile2; 2-byte block
+		"abc", blockList(0, 3), // afile4, file3; 2-byte block
+		"bc\n", blockList(0, 3), // afile4, file3; 2-byte block
+		"dab", blockList(0), // afile4; 1-byte block
+		"xyz", blockList(4), // file5; 2-byte block
+		"yzw", blockList(4), // file5; 2-byte block
+		"zw\n", blockList(4), // file5; 2-byte block
+		"\xff\xff\xff", fileList64(),
+	),
+
+	// name index
+	pad(16,
+		u64(0),
+	),
+
+	// file metadata section
+	pad(16,
+		meta(trivialFiles["afile4"]),
+		meta(trivialFiles["f0"]),
+		meta(trivialFiles["file1"]),
+		meta(trivialFiles["file3"]),
+		meta(trivialFiles["file5"]),
+		meta(trivialFiles["the/file"]),
+	),
+
+	// posting list index block
+	pad(postBlockSize,
+		"\na\n", uv(1), uv(0), "\x02",
+		"\nab", uv(2), uv(5), "\x01",
+		"\nda", uv(1), uv(6), "\x02",
+		"\nxy", uv(1), uv(4), "\x02",
+		"ab\n", uv(1), uv(5), "\x02",
+		"abc", uv(2), uv(5), "\x02",
+		"bc\n", uv(2), uv(5), "\x02",
+		"dab", uv(1), uv(5), "\x02",
+		"xyz", uv(1), uv(4), "\x02",
+		"yzw", uv(1), uv(5), "\x02",
+		"zw\n", uv(1), uv(5), "\x02",
+		"\xff\xff\xff", uv(0), uv(5), "\x00",
+	),
+
+	// trailer
+	u64(0x10),  // offset to list of paths
+	u64(0),     // number of paths
+	u64(0x10),  // offset to list of names
+	u64(6),     // number of names
+	u64(0x40),  // offset to posting lists
+	u64(12),    // number of posting lists / trigrams
+	u64(0x80),  // offset to name index
+	u64(0x1b0), // offset to posting index
+
+	// optional sections
+	"filemeta", u64(0x90), u64(6*metaRecordSize),
+	u64(1), // number of optional sections
+
+	"\ncsearch trlr 4\n",
+)
+
+// meta returns the file metadata record for a file with the given content
+// and an unknown modification time.
+func meta(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return u64(uint64(len(content))) + u64(0) + string(sum[:])
+}
+
 func pad(n int, list ...string) string {
 	s := strings.Join(list, "")
 	frag := len(s) % n
@@ -248,6 +419,43 @@ func fileList64(list ...int) string {
 	return string(buf)
 }
 
+// blockList returns the encBlock encoding of a list
+// of at most blockLen file IDs.
+func blockList(list ...int) string {
+	w := 0
+	last := -1
+	for _, x := range list {
+		for x-last-1 >= 1<<w {
+			w++
+		}
+		last = x
+	}
+	buf := []byte{byte(w)}
+	nb := 0
+	last = -1
+	for _, x := range list {
+		for i := range w {
+			if nb%8 == 0 {
+				buf = append(buf, 0)
+			}
+			buf[len(buf)-1] |= byte((x-last-1)>>i&1) << (nb % 8)
+			nb++
+		}
+		last = x
+	}
+	return string(buf)
+}
+
+// bitmapList returns the encBitmap encoding of a non-empty list of file IDs.
+func bitmapList(list ...int) string {
+	base := list[0]
+	bitmap := make([]byte, (list[len(list)-1]-base)/8+1)
+	for _, x := range list {
+		bitmap[(x-base)/8] |= 1 << ((x - base) % 8)
+	}
+	return uv(base) + uv(len(bitmap)) + string(bitmap)
+}
+
 type stringFile struct {
 	*strings.Reader
 	name string
@@ -273,9 +481,15 @@ func apply[In, Out any](f func(In) Out, xs []In) []Out {
 	return ys
 }
 
-func buildFlushIndex(out string, roots []string, doFlush bool, fileData map[string]string) {
+// buildFlushIndex builds an index of fileData in out, flushing the
+// posting lists to disk along the way if doFlush is set. The config
+// functions, if any, configure the IndexWriter before the files are added.
+func buildFlushIndex(out string, roots []string, doFlush bool, fileData map[string]string, config ...func(*IndexWriter)) {
 	ix := Create(out)
 	ix.Zip = true
+	for _, f := range config {
+		f(ix)
+	}
 
 	ix.AddRoots(apply(MakePath, roots))
 	var files []string
@@ -310,7 +524,7 @@ func testTrivialWrite(t *testing.T, doFlush bool) {
 		writeVersion = old
 	}()
 
-	for v := 1; v <= 2; v++ {
+	for v := 1; v <= 4; v++ {
 		t.Run(fmt.Sprint("V", v), func(t *testing.T) {
 			writeVersion = v
 			f, _ := os.CreateTemp("", "index-test")
@@ -323,10 +537,15 @@ func testTrivialWrite(t *testing.T, doFlush bool) {
 				t.Fatalf("reading _test/index.triv: %v", err)
 			}
 			var want []byte
-			if v == 1 {
+			switch v {
+			case 1:
 				want = []byte(trivialIndexV1)
-			} else {
+			case 2:
 				want = []byte(trivialIndexV2)
+			case 3:
+				want = []byte(trivialIndexV3)
+			case 4:
+				want = []byte(trivialIndexV4)
 			}
 			if !bytes.Equal(data, want) {
 				i := 0
@@ -410,3 +629,377 @@ func TestZip(t *testing.T) {
 	checkPosting(t, ix, "now", 3, 4, 6)
 	checkPosting(t, ix, "pot", 4, 5, 7)
 }
+
+func TestReuse(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a/x":  "hello world",
+		"a/y":  "goodbye world",
+		"b/xx": "now is the time",
+		"b/xy": "for all good men",
+		"c/ab": "give me all the potatoes",
+		"c/de": "or give me death now",
+	}
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	write := func(name, data string) {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := func(out string, old *Index) *IndexWriter {
+		ix := Create(out)
+		if old != nil {
+			ix.Reuse(old)
+		}
+		ix.AddRoots([]Path{MakePath(dir)})
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				ix.AddFile(path)
+			}
+			return nil
+		})
+		ix.Flush()
+		return ix
+	}
+
+	for name, data := range files {
+		write(name, data)
+	}
+	out1 := filepath.Join(t.TempDir(), "index1")
+	build(out1, nil)
+
+	mtime = mtime.Add(time.Second)
+	write("a/y", "goodbye cruel world") // new size
+	write("b/xy", "for all good mem")   // same size, new mtime
+	write("b/z", "a brand new file")
+	os.Remove(filepath.Join(dir, "c/ab"))
+
+	out2 := filepath.Join(t.TempDir(), "index2")
+	ix2 := build(out2, Open(out1))
+	if n := ix2.reused(); n != 3 {
+		t.Errorf("reused %d files, want 3", n)
+	}
+	out3 := filepath.Join(t.TempDir(), "index3")
+	build(out3, nil)
+
+	data2, _ := os.ReadFile(out2)
+	data3, _ := os.ReadFile(out3)
+	if !bytes.Equal(data2, data3) {
+		t.Fatalf("incremental index differs from full index:\nhave:\n%s\nwant:\n%s", hex.Dump(data2), hex.Dump(data3))
+	}
+	ix := Open(out2)
+	if err := ix.Check(); err != nil {
+		t.Fatal(err)
+	}
+	checkPosting(t, ix, "mem", 3)
+	checkPosting(t, ix, "wor", 0, 1)
+}
+
+func TestReuseArchive(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range []string{"m/one", "m/two", "three"} {
+		ww, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ww.Write([]byte("potatoes " + name))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, map[string]string{
+		"a.c":   "int apple;\n",
+		"b.zip": buf.String(),
+		"c.c":   "int cherry;\n",
+	})
+	build := func(out string, old *Index) *IndexWriter {
+		ix := Create(out)
+		ix.Zip = true
+		if old != nil {
+			ix.Reuse(old)
+		}
+		ix.AddRoots([]Path{MakePath(dir)})
+		for _, name := range []string{"a.c", "b.zip", "c.c"} {
+			if err := ix.AddFile(filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ix.Flush()
+		return ix
+	}
+
+	out1 := filepath.Join(t.TempDir(), "index1")
+	build(out1, nil)
+	ix1 := Open(out1)
+	if len(ix1.archives) != 1 || ix1.archives[0].fileid != 1 {
+		t.Fatalf("archives = %v, want one starting at 1", ix1.archives)
+	}
+
+	// An unchanged archive is reused, members and all.
+	out2 := filepath.Join(t.TempDir(), "index2")
+	if n := build(out2, ix1).reused(); n != 5 {
+		t.Errorf("reused %d files, want 5", n)
+	}
+	data1, _ := os.ReadFile(out1)
+	data2, _ := os.ReadFile(out2)
+	if !bytes.Equal(data1, data2) {
+		t.Fatalf("incremental index differs from full index")
+	}
+
+	// A changed archive is read again.
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "b.zip"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	out3 := filepath.Join(t.TempDir(), "index3")
+	if n := build(out3, ix1).reused(); n != 2 {
+		t.Errorf("after touching archive, reused %d files, want 2", n)
+	}
+	ix3 := Open(out3)
+	if a, ok := ix3.archive(1); !ok || !a.modTime.Equal(mtime) {
+		t.Errorf("archive(1) = %v, %v, want modification time %v", a, ok, mtime)
+	}
+	checkPosting(t, ix3, "pot", 1, 2, 3)
+
+	// Merging renumbers the archives.
+	f, _ := os.CreateTemp("", "index-test")
+	defer os.Remove(f.Name())
+	buildIndex(f.Name(), []string{"/0"}, map[string]string{"/0/x": "x"})
+	out4 := filepath.Join(t.TempDir(), "index4")
+	Merge(out4, f.Name(), out3)
+	ix4 := Open(out4)
+	if err := ix4.Check(); err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := ix4.archive(2); !ok || !a.modTime.Equal(mtime) || len(ix4.archives) != 1 {
+		t.Errorf("merged archives = %v, want one starting at 2", ix4.archives)
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	dir := t.TempDir()
+	files := skipFiles(dir, 500)
+	files[filepath.Join(dir, "binary")] = "a\x00b"
+	files[filepath.Join(dir, "latin1")] = "caf\xe9"
+	for name, data := range files {
+		if err := os.WriteFile(name, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := func(out string, workers int, old *Index) {
+		ix := Create(out)
+		ix.Workers = workers
+		if old != nil {
+			ix.Reuse(old)
+		}
+		ix.AddRoots([]Path{MakePath(dir)})
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				ix.AddFile(path)
+			}
+			return nil
+		})
+		ix.Flush()
+	}
+
+	out1 := filepath.Join(t.TempDir(), "index1")
+	build(out1, 1, nil)
+	want, _ := os.ReadFile(out1)
+	for _, workers := range []int{2, 8} {
+		out := filepath.Join(t.TempDir(), "index")
+		build(out, workers, nil)
+		if have, _ := os.ReadFile(out); !bytes.Equal(have, want) {
+			t.Errorf("index built with %d workers differs from serial build", workers)
+		}
+	}
+
+	// Reused files interleave correctly with files read by workers.
+	mtime := time.Now().Add(time.Hour)
+	for i := 0; i < 500; i += 7 {
+		name := filepath.Join(dir, fmt.Sprintf("file%04d", i))
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out2 := filepath.Join(t.TempDir(), "index2")
+	build(out2, 1, nil)
+	want, _ = os.ReadFile(out2)
+	out3 := filepath.Join(t.TempDir(), "index3")
+	build(out3, 4, Open(out1))
+	if have, _ := os.ReadFile(out3); !bytes.Equal(have, want) {
+		t.Errorf("incremental index built with workers differs from full build")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	long := strings.Repeat("long line ", 300) + "\n"
+	files := map[string]string{
+		"/a/big.txt":         strings.Repeat("short line\n", 100),
+		"/a/data.json":       long,
+		"/a/lib.js":          long,
+		"/a/lib.min.js":      long,
+		"/a/small.txt":       "hello world\n",
+		"/a/x.zip\x01z.json": long,
+	}
+	for _, tt := range []struct {
+		limits    Limits
+		overrides []LimitOverride
+		want      []string
+	}{
+		{
+			want: []string{"/a/big.txt", "/a/small.txt"},
+		},
+		{
+			limits: Limits{MaxLineLen: 5000},
+			want:   []string{"/a/big.txt", "/a/data.json", "/a/lib.js", "/a/lib.min.js", "/a/small.txt"},
+		},
+		{
+			limits: Limits{MaxFileLen: 1000, MaxLineLen: -1},
+			want:   []string{"/a/small.txt"},
+		},
+		{
+			limits: Limits{MaxLineLen: -1},
+			overrides: []LimitOverride{
+				{Pattern: "*.js", Limits: Limits{MaxLineLen: 100}},
+				{Pattern: "*.txt", Limits: Limits{MaxTextTrigrams: 5}},
+			},
+			want: []string{"/a/data.json"},
+		},
+		{
+			overrides: []LimitOverride{
+				{Pattern: "*.json", Limits: Limits{MaxLineLen: -1}},
+				{Pattern: "*.js", Limits: Limits{MaxLineLen: 5000}},
+				{Pattern: "*.min.js", Limits: Limits{MaxLineLen: 100}},
+				{Pattern: "/a/big.txt", Limits: Limits{MaxFileLen: 100}},
+			},
+			want: []string{"/a/data.json", "/a/lib.js", "/a/small.txt"},
+		},
+	} {
+		out := filepath.Join(t.TempDir(), "index")
+		ix := Create(out)
+		ix.Limits = tt.limits
+		ix.LimitOverrides = tt.overrides
+		names := slices.Sorted(maps.Keys(files))
+		for _, name := range names {
+			ix.Add(name, strings.NewReader(files[name]))
+		}
+		ix.Flush()
+
+		var have []string
+		rd := Open(out)
+		for name := range rd.Names(0, rd.numName) {
+			have = append(have, name.String())
+		}
+		if !slices.Equal(have, tt.want) {
+			t.Errorf("Limits %+v %+v: indexed %q, want %q", tt.limits, tt.overrides, have, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.json", "/a/b/c.json", true},
+		{"*.json", "/a/b.json/c", false},
+		{"*.json", "/a/x.zip\x01dir/c.json", true},
+		{"dir/*.json", "/a/x.zip\x01dir/c.json", true},
+		{"/a/*/c.json", "/a/b/c.json", true},
+		{"/a/*.json", "/a/b/c.json", false},
+	} {
+		o := &LimitOverride{Pattern: tt.pattern}
+		if have := o.Match(tt.name); have != tt.want {
+			t.Errorf("LimitOverride{%q}.Match(%q) = %v, want %v", tt.pattern, tt.name, have, tt.want)
+		}
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	files := map[string]string{
+		"/s/binary":    "a\x00b",
+		"/s/invalid":   "caf\xe9 \x1b au lait",
+		"/s/long":      strings.Repeat("xx\n", 100),
+		"/s/lines":     strings.Repeat("y", 60) + "\n",
+		"/s/many":      "abcdefghijklmnopqrstuvwxyz",
+		"/s/bad\tname": "fine",
+		"/s/ok":        "hello world",
+	}
+	type skip struct {
+		name   string
+		reason SkipReason
+		size   int64
+	}
+	var have []skip
+	out := filepath.Join(t.TempDir(), "index")
+	ix := Create(out)
+	ix.Limits = Limits{MaxFileLen: 200, MaxLineLen: 50, MaxTextTrigrams: 20}
+	ix.Skipped = func(name string, reason SkipReason, size int64) {
+		have = append(have, skip{name, reason, size})
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		ix.Add(name, &stringFile{strings.NewReader(files[name]), name, int64(len(files[name]))})
+	}
+	ix.Flush()
+
+	want := []skip{
+		{"/s/bad\tname", SkipName, 4},
+		{"/s/binary", SkipNUL, 3},
+		{"/s/invalid", SkipUTF8, 14},
+		{"/s/lines", SkipLongLines, 61},
+		{"/s/long", SkipTooLong, 300},
+		{"/s/many", SkipTrigrams, 26},
+	}
+	if !slices.Equal(have, want) {
+		t.Errorf("skipped:\nhave %v\nwant %v", have, want)
+	}
+	if s := SkipLongLines.String(); s != "long lines" {
+		t.Errorf("SkipLongLines.String() = %q, want %q", s, "long lines")
+	}
+}
+
+func TestBufferError(t *testing.T) {
+	// A Buffer on a read-only file records the write error
+	// instead of exiting, and copying it passes the error on.
+	name := filepath.Join(t.TempDir(), "ro")
+	if err := os.WriteFile(name, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := &Buffer{name: name, file: f, buf: make([]byte, 0, 16)}
+	b.WriteString("hello, world\n")
+	if b.err != nil {
+		t.Fatalf("buffered write failed: %v", b.err)
+	}
+	b.Write(bytes.Repeat([]byte("x"), 100))
+	if b.err == nil {
+		t.Fatalf("write to read-only file succeeded")
+	}
+	if n := b.Offset(); n != 113 {
+		t.Errorf("Offset after failed write = %d, want 113", n)
+	}
+
+	dst, err := createBuffer(filepath.Join(t.TempDir(), "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.file.Close()
+	copyFile(dst, b)
+	if dst.err != b.err {
+		t.Errorf("copyFile error = %v, want %v", dst.err, b.err)
+	}
+	if _, err := createBuffer(filepath.Join(name, "x")); err == nil {
+		t.Errorf("createBuffer in file succeeded")
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"strings"
 
 	"github.com/google/codesearch/sparse"
+	"github.com/google/codesearch/transcode"
 )
 
 // A matcher holds the state for running regular expression search.
@@ -372,7 +373,8 @@ type Grep struct {
 	PreContext  int // number of lines to print after
 	PostContext int // number of lines to print before
 
-	buf []byte
+	buf  []byte
+	text *transcode.Reader
 }
 
 func (g *Grep) AddFlags() {
@@ -429,10 +431,19 @@ func countNL(b []byte) int {
 	return n
 }
 
+// Reader searches the text read from r, reporting matches in the file
+// with the given name. Like the indexer, it decodes text in other
+// encodings to UTF-8 first; see package transcode.
 func (g *Grep) Reader(r io.Reader, name string) {
 	if g.buf == nil {
 		g.buf = make([]byte, 1<<20)
 	}
+	if g.text == nil {
+		g.text = transcode.NewReader(r)
+	} else {
+		g.text.Reset(r)
+	}
+	r = g.text
 	var (
 		buf        = g.buf[:0]
 		needLineno = g.N || g.HTML
@@ -197,6 +197,9 @@ var grepTests = []struct {
 }{
 	{re: `a+`, s: "abc\ndef\nghalloo\n", out: "input:abc\ninput:ghalloo\n"},
 	{re: `x.*y`, s: "xay\nxa\ny\n", out: "input:xay\n"},
+	{re: `café`, s: "th\xe9\ncaf\xe9\n", out: "input:2:café\n", g: Grep{N: true}},
+	{re: `b.$`, s: "\xff\xfea\x00\n\x00b\x00c\x00\n\x00", out: "input:2:bc\n", g: Grep{N: true}},
+	{re: `é`, s: "\xfe\xff\x00\xe9\x00\n", out: "input:é\n"},
 }
 
 func TestGrep(t *testing.T) {
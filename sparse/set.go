@@ -63,3 +63,79 @@ func (s *Set) Dense() []uint32 {
 func (s *Set) Len() int {
 	return len(s.dense)
 }
+
+// A PagedSet is a sparse set of uint32 values, like Set, but it
+// allocates its sparse array in pages as values are added, so that
+// its memory use grows with the range of values the set has held
+// rather than the maximum size. Like Set, it can be reset in O(1) time.
+type PagedSet struct {
+	dense []uint32
+	pages []*[pageSize]uint32
+}
+
+const (
+	pageBits = 10
+	pageSize = 1 << pageBits
+)
+
+// NewPagedSet returns a new PagedSet with a given maximum size.
+// The set can contain numbers in [0, max-1].
+func NewPagedSet(max uint32) *PagedSet {
+	return &PagedSet{
+		pages: make([]*[pageSize]uint32, (uint64(max)+pageSize-1)/pageSize),
+	}
+}
+
+// Reset clears (empties) the set.
+// The pages allocated so far are kept for reuse.
+func (s *PagedSet) Reset() {
+	s.dense = s.dense[:0]
+}
+
+// Add adds x to the set if it is not already there.
+func (s *PagedSet) Add(x uint32) {
+	p := s.pages[x>>pageBits]
+	if p == nil {
+		p = new([pageSize]uint32)
+		s.pages[x>>pageBits] = p
+	}
+	v := p[x%pageSize]
+	if v < uint32(len(s.dense)) && s.dense[v] == x {
+		return
+	}
+	p[x%pageSize] = uint32(len(s.dense))
+	s.dense = append(s.dense, x)
+}
+
+// Has reports whether x is in the set.
+func (s *PagedSet) Has(x uint32) bool {
+	p := s.pages[x>>pageBits]
+	if p == nil {
+		return false
+	}
+	v := p[x%pageSize]
+	return v < uint32(len(s.dense)) && s.dense[v] == x
+}
+
+// Dense returns the values in the set.
+// The values are listed in the order in which they
+// were inserted.
+func (s *PagedSet) Dense() []uint32 {
+	return s.dense
+}
+
+// Len returns the number of values in the set.
+func (s *PagedSet) Len() int {
+	return len(s.dense)
+}
+
+// pagesAllocated returns the number of pages the set has allocated.
+func (s *PagedSet) pagesAllocated() int {
+	n := 0
+	for _, p := range s.pages {
+		if p != nil {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sparse
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestPagedSet(t *testing.T) {
+	const max = 1 << 24
+	r := rand.New(rand.NewSource(1))
+	s := NewPagedSet(max)
+	ref := NewSet(max)
+	for round := range 10 {
+		s.Reset()
+		ref.Reset()
+		// Cluster the values in a few pages, as trigrams do.
+		base := uint32(r.Intn(max - 1<<16))
+		for range 5000 {
+			x := base + uint32(r.Intn(1<<16))
+			if s.Has(x) != ref.Has(x) {
+				t.Fatalf("round %d: Has(%d) = %v, want %v", round, x, s.Has(x), ref.Has(x))
+			}
+			s.Add(x)
+			ref.Add(x)
+		}
+		if !slices.Equal(s.Dense(), ref.Dense()) || s.Len() != ref.Len() {
+			t.Fatalf("round %d: Dense differs from Set", round)
+		}
+		for range 1000 {
+			x := uint32(r.Intn(max))
+			if s.Has(x) != ref.Has(x) {
+				t.Fatalf("round %d: Has(%d) = %v, want %v", round, x, s.Has(x), ref.Has(x))
+			}
+		}
+	}
+	if n, all := s.pagesAllocated(), max/pageSize; n > all/10 {
+		t.Errorf("allocated %d of %d pages", n, all)
+	}
+	s.Add(max - 1)
+	if !s.Has(max-1) || s.Has(max-2) {
+		t.Errorf("Has wrong at end of range")
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package transcode detects the encoding of text files
+// and decodes them to UTF-8.
+//
+// The indexer and the searchers must decode a file the same way,
+// so that the text they see, and therefore the matches and line
+// numbers they report, are the same. Both use a Reader.
+package transcode
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// An Encoding is a text encoding.
+// The values are recorded in indexes and must not change.
+type Encoding int
+
+const (
+	UTF8    Encoding = iota // UTF-8, with or without a byte order mark
+	UTF16LE                 // UTF-16, little-endian
+	UTF16BE                 // UTF-16, big-endian
+	Latin1                  // ISO 8859-1
+)
+
+var encodingNames = []string{
+	UTF8:    "UTF-8",
+	UTF16LE: "UTF-16LE",
+	UTF16BE: "UTF-16BE",
+	Latin1:  "Latin-1",
+}
+
+func (e Encoding) String() string {
+	if 0 <= e && int(e) < len(encodingNames) {
+		return encodingNames[e]
+	}
+	return "Encoding(" + strconv.Itoa(int(e)) + ")"
+}
+
+// sniffLen is the number of bytes Detect needs to see
+// to decide the encoding of a file with no byte order mark.
+const sniffLen = 4096
+
+// Detect returns the encoding of text beginning with prefix.
+// A byte order mark identifies UTF-8 or UTF-16. Otherwise, Detect
+// guesses: text in which most pairs of bytes are an ASCII character
+// and a NUL is UTF-16; other text that is valid UTF-8 is UTF-8;
+// and text with no NULs or other control characters that is not
+// valid UTF-8 is Latin-1. Anything else, including binary data,
+// is reported as UTF-8, leaving it to the caller to reject it.
+//
+// If prefix is only the beginning of the text, it should be at
+// least 4096 bytes long; atEOF reports whether it is the whole text.
+// Text that is ASCII in its first 4096 bytes is reported as UTF-8,
+// even if it is Latin-1 later on; a Reader corrects that.
+func Detect(prefix []byte, atEOF bool) Encoding {
+	switch {
+	case len(prefix) >= 3 && prefix[0] == 0xEF && prefix[1] == 0xBB && prefix[2] == 0xBF:
+		return UTF8
+	case len(prefix) >= 2 && prefix[0] == 0xFF && prefix[1] == 0xFE:
+		return UTF16LE
+	case len(prefix) >= 2 && prefix[0] == 0xFE && prefix[1] == 0xFF:
+		return UTF16BE
+	}
+	if e, ok := detectUTF16(prefix, atEOF); ok {
+		return e
+	}
+	if validUTF8(prefix, atEOF) || hasControls(prefix) {
+		return UTF8
+	}
+	return Latin1
+}
+
+// hasControls reports whether b contains control characters
+// other than the ones common in text.
+func hasControls(b []byte) bool {
+	for _, c := range b {
+		if c < ' ' && c != '\t' && c != '\n' && c != '\v' && c != '\f' && c != '\r' {
+			return true
+		}
+	}
+	return false
+}
+
+// validUTF8 reports whether b is valid UTF-8,
+// allowing an incomplete final character unless atEOF is set.
+func validUTF8(b []byte, atEOF bool) bool {
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			return !atEOF && !utf8.FullRune(b[i:])
+		}
+		i += size
+	}
+	return true
+}
+
+// detectUTF16 guesses whether b is UTF-16 text with no byte order mark.
+// ASCII text in UTF-16 has a NUL in every other byte, so b is likely
+// UTF-16 if most of its code units have a NUL on the same side and
+// none is entirely NUL. UTF-16 text has an even length.
+func detectUTF16(b []byte, atEOF bool) (Encoding, bool) {
+	if atEOF && len(b)%2 != 0 {
+		return 0, false
+	}
+	n, lo, hi := 0, 0, 0
+	for i := 0; i+1 < len(b); i += 2 {
+		switch {
+		case b[i] == 0 && b[i+1] == 0:
+			return 0, false
+		case b[i] == 0:
+			hi++
+		case b[i+1] == 0:
+			lo++
+		}
+		n++
+	}
+	switch {
+	case n == 0:
+		return 0, false
+	case lo > n/2 && hi == 0:
+		return UTF16LE, true
+	case hi > n/2 && lo == 0:
+		return UTF16BE, true
+	}
+	return 0, false
+}
+
+// A Reader decodes text read from an underlying reader to UTF-8.
+// It detects the encoding of the text with Detect when first read.
+// UTF-8 text, including any byte order mark, and data in
+// no recognized encoding pass through unchanged. A UTF-16 byte
+// order mark is removed, and invalid UTF-16 decodes to U+FFFD.
+//
+// Detect only looks at the start of the text, so a Reader checks
+// again at the first non-ASCII byte of text detected as UTF-8: if the
+// text there looks like Latin-1 by the same rules, the Reader decodes
+// the rest of it as Latin-1, which is exact, since the text before is
+// ASCII. After that, Encoding reports Latin1.
+type Reader struct {
+	r        *bufio.Reader
+	enc      Encoding
+	err      error  // error from detection
+	detected bool   // enc and err are set
+	ascii    bool   // text so far is ASCII, detected as UTF-8
+	out      []byte // decoded text not yet returned
+	outbuf   [sniffLen]byte
+}
+
+// NewReader returns a Reader decoding the text read from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, sniffLen)}
+}
+
+// Reset discards the Reader's state and makes it read from r,
+// so that a single Reader can decode many files.
+func (d *Reader) Reset(r io.Reader) {
+	d.r.Reset(r)
+	d.enc = UTF8
+	d.err = nil
+	d.detected = false
+	d.ascii = false
+	d.out = nil
+}
+
+// Encoding returns the encoding of the text. For text that is ASCII
+// at first, it may change from UTF8 to Latin1 as the text is read.
+func (d *Reader) Encoding() (Encoding, error) {
+	d.detect()
+	return d.enc, d.err
+}
+
+func (d *Reader) detect() {
+	if d.detected {
+		return
+	}
+	d.detected = true
+	b, err := d.r.Peek(sniffLen)
+	atEOF := false
+	if err != nil {
+		if err != io.EOF && err != bufio.ErrBufferFull {
+			d.err = err
+			return
+		}
+		atEOF = err == io.EOF
+	}
+	d.enc = Detect(b, atEOF)
+	switch d.enc {
+	case UTF8:
+		d.ascii = true
+	case UTF16LE:
+		if len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE {
+			d.r.Discard(2)
+		}
+	case UTF16BE:
+		if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+			d.r.Discard(2)
+		}
+	}
+}
+
+func (d *Reader) Read(p []byte) (int, error) {
+	d.detect()
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.ascii {
+		if n, ok, err := d.readASCII(p); ok {
+			return n, err
+		}
+	}
+	if d.enc == UTF8 {
+		return d.r.Read(p)
+	}
+	if len(d.out) == 0 {
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// readASCII reads the ASCII text at the start of the input into p,
+// reporting whether it did. At the first non-ASCII byte, it decides
+// whether the rest of the text is Latin-1 and reports false.
+func (d *Reader) readASCII(p []byte) (int, bool, error) {
+	if len(p) == 0 {
+		return 0, true, nil
+	}
+	b, err := d.r.Peek(sniffLen)
+	if len(b) == 0 {
+		return 0, true, err
+	}
+	k := 0
+	for k < len(b) && k < len(p) && b[k] < utf8.RuneSelf {
+		k++
+	}
+	if k > 0 {
+		copy(p, b[:k])
+		d.r.Discard(k)
+		return k, true, nil
+	}
+	d.ascii = false
+	if !validUTF8(b, err == io.EOF) && !hasControls(b) {
+		d.enc = Latin1
+	}
+	return 0, false, nil
+}
+
+// fill decodes the next block of text into d.out.
+func (d *Reader) fill() error {
+	out := d.outbuf[:0]
+	for len(out) < len(d.outbuf)-2*utf8.UTFMax {
+		var r rune
+		switch d.enc {
+		case Latin1:
+			c, err := d.r.ReadByte()
+			if err != nil {
+				return d.flush(out, err)
+			}
+			r = rune(c)
+		case UTF16LE, UTF16BE:
+			u, err := d.readUnit()
+			if err != nil {
+				return d.flush(out, err)
+			}
+			r = rune(u)
+			if utf16.IsSurrogate(r) {
+				r = utf8.RuneError
+				if u < 0xDC00 {
+					if u2, err := d.peekUnit(); err == nil && 0xDC00 <= u2 && u2 < 0xE000 {
+						d.r.Discard(2)
+						r = utf16.DecodeRune(rune(u), rune(u2))
+					}
+				}
+			}
+		}
+		out = utf8.AppendRune(out, r)
+	}
+	d.out = out
+	return nil
+}
+
+// flush makes out the decoded text if it is not empty,
+// and otherwise returns err.
+func (d *Reader) flush(out []byte, err error) error {
+	if len(out) == 0 {
+		return err
+	}
+	d.out = out
+	return nil
+}
+
+// readUnit reads a UTF-16 code unit. A final odd byte decodes as U+FFFD.
+func (d *Reader) readUnit() (uint16, error) {
+	u, err := d.peekUnit()
+	if err == io.ErrUnexpectedEOF {
+		d.r.Discard(1)
+		return utf8.RuneError, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	d.r.Discard(2)
+	return u, nil
+}
+
+// peekUnit returns the next UTF-16 code unit without consuming it.
+// It returns io.ErrUnexpectedEOF if only one byte remains.
+func (d *Reader) peekUnit() (uint16, error) {
+	b, err := d.r.Peek(2)
+	if len(b) < 2 {
+		if len(b) == 1 && err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	if d.enc == UTF16LE {
+		return uint16(b[0]) | uint16(b[1])<<8, nil
+	}
+	return uint16(b[0])<<8 | uint16(b[1]), nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package transcode
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+func utf16le(s string) string {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return string(b)
+}
+
+func utf16be(s string) string {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return string(b)
+}
+
+var decodeTests = []struct {
+	in  string
+	enc Encoding
+	out string
+}{
+	{"", UTF8, ""},
+	{"hello, world\n", UTF8, "hello, world\n"},
+	{"\xef\xbb\xbfhello\n", UTF8, "\xef\xbb\xbfhello\n"},
+	{"caf\xc3\xa9\n", UTF8, "café\n"},
+	{"caf\xe9\n", Latin1, "café\n"},
+	{"caf\xe9\x1b\n", UTF8, "caf\xe9\x1b\n"},
+	{"a\x00b", UTF8, "a\x00b"},
+	{"\xff\xfe" + utf16le("héllo, 世界 😀\n"), UTF16LE, "héllo, 世界 😀\n"},
+	{"\xfe\xff" + utf16be("héllo, 世界 😀\n"), UTF16BE, "héllo, 世界 😀\n"},
+	{utf16le("#define X 1\r\n"), UTF16LE, "#define X 1\r\n"},
+	{utf16be("#define X 1\r\n"), UTF16BE, "#define X 1\r\n"},
+	{"\xff\xfeab\x00\xd8c", UTF16LE, "扡��"},
+	{"\xff\xfe\x00\xd8a\x00", UTF16LE, "�a"},
+	{"\xff\xfe", UTF16LE, ""},
+}
+
+func TestReader(t *testing.T) {
+	for _, tt := range decodeTests {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.in)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			d := NewReader(r)
+			enc, err := d.Encoding()
+			if err != nil || enc != tt.enc {
+				t.Errorf("Encoding(%q) = %v, %v, want %v", tt.in, enc, err, tt.enc)
+			}
+			out, err := io.ReadAll(iotest.OneByteReader(d))
+			if err != nil || string(out) != tt.out {
+				t.Errorf("decode %q = %q, %v, want %q", tt.in, out, err, tt.out)
+			}
+		}
+	}
+}
+
+func TestReaderLong(t *testing.T) {
+	// Text longer than the detection window and the output buffer.
+	text := strings.Repeat("ünïcödé text\n", 1000) + "end\n"
+	latin1 := make([]byte, 0, len(text))
+	for _, r := range text {
+		latin1 = append(latin1, byte(r))
+	}
+	for _, in := range []string{text, string(latin1), utf16le(text), "\xfe\xff" + utf16be(text)} {
+		d := NewReader(strings.NewReader("stale"))
+		d.Reset(strings.NewReader(in))
+		out, err := io.ReadAll(d)
+		if err != nil || string(out) != text {
+			enc, _ := d.Encoding()
+			t.Errorf("decode %v: %d bytes, %v, want %d bytes", enc, len(out), err, len(text))
+		}
+	}
+}
+
+func TestReaderLate(t *testing.T) {
+	// Text that is ASCII for longer than the detection window.
+	ascii := strings.Repeat("plain text\n", 500)
+	for _, tt := range []struct {
+		in  string
+		enc Encoding
+		out string
+	}{
+		{ascii + "caf\xe9 au lait\n", Latin1, ascii + "café au lait\n"},
+		{ascii + "caf\xc3\xa9 au lait\n", UTF8, ascii + "café au lait\n"},
+		{ascii + "caf\xe9\x1b\n", UTF8, ascii + "caf\xe9\x1b\n"},
+		{ascii + "caf\xc3\xa9 caf\xe9\n", Latin1, ascii + "cafÃ© café\n"}, // as Detect would say
+	} {
+		for _, oneByte := range []bool{false, true} {
+			var r io.Reader = strings.NewReader(tt.in)
+			if oneByte {
+				r = iotest.OneByteReader(r)
+			}
+			d := NewReader(r)
+			if enc, _ := d.Encoding(); enc != UTF8 {
+				t.Errorf("Encoding before reading = %v, want UTF-8", enc)
+			}
+			out, err := io.ReadAll(d)
+			if err != nil || string(out) != tt.out {
+				t.Errorf("decode %q: %q, %v, want %q", tt.in[len(ascii):], out[min(len(ascii), len(out)):], err, tt.out[len(ascii):])
+			}
+			if enc, _ := d.Encoding(); enc != tt.enc {
+				t.Errorf("decode %q: Encoding = %v, want %v", tt.in[len(ascii):], enc, tt.enc)
+			}
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	// A prefix cut in the middle of a character is still UTF-8.
+	b := []byte(strings.Repeat("é", 3000))[:4095]
+	if enc := Detect(b, false); enc != UTF8 {
+		t.Errorf("Detect(truncated UTF-8) = %v, want UTF-8", enc)
+	}
+	if enc := Detect(b, true); enc != Latin1 {
+		t.Errorf("Detect(truncated UTF-8, atEOF) = %v, want Latin-1", enc)
+	}
+	// Binary data is reported as UTF-8, for the caller to reject.
+	bin := bytes.Repeat([]byte{0x89, 'P', 'N', 'G', 0, 0, 0, 0x0d}, 10)
+	if enc := Detect(bin, true); enc != UTF8 {
+		t.Errorf("Detect(binary) = %v, want UTF-8", enc)
+	}
+	if s := UTF16LE.String(); s != "UTF-16LE" {
+		t.Errorf("UTF16LE.String() = %q", s)
+	}
+}